@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/reugn/auth-server/internal/auth"
 	"github.com/reugn/auth-server/internal/config"
 	"github.com/reugn/auth-server/internal/http"
+	"github.com/reugn/auth-server/internal/repository"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
 
 const (
 	version = "0.4.0"
+	// shutdownTimeout is the maximum time to wait for the active requests
+	// to complete on shutdown.
+	shutdownTimeout = 10 * time.Second
 )
 
 func run() int {
@@ -46,10 +55,25 @@ func run() int {
 		if err != nil {
 			return err
 		}
+		// reload the keyring on SIGHUP to promote a new signing key
+		signingMethod, err := config.JWTSigningMethod()
+		if err != nil {
+			return err
+		}
+		go reloadKeysOnSignal(configFilePath, keys, signingMethod)
+		// shut down the server gracefully on SIGINT or SIGTERM
+		shutdown := make(chan error, 1)
+		go shutdownOnSignal(server, shutdown)
 		slog.Info("Starting service", "config", config)
-		return server.Start()
+		if err := server.Start(); err != nil {
+			server.Close()
+			return err
+		}
+		return <-shutdown
 	}
 
+	rootCmd.AddCommand(newMigrateCommand())
+
 	err := rootCmd.Execute()
 	if err != nil {
 		return 1
@@ -57,14 +81,102 @@ func run() int {
 	return 0
 }
 
+// newMigrateCommand returns the command hashing the plaintext passwords and secrets
+// of the local repository configuration file in place.
+func newMigrateCommand() *cobra.Command {
+	var configFilePath, algorithm string
+	migrateCmd := &cobra.Command{
+		Use:   "migrate <local-config-path>",
+		Short: "Hash plaintext passwords and secrets of the local repository configuration",
+		Args:  cobra.ExactArgs(1),
+	}
+	migrateCmd.Flags().StringVarP(&configFilePath, "config", "c", "",
+		"configuration file path to read the password hashing parameters from")
+	migrateCmd.Flags().StringVarP(&algorithm, "algorithm", "a", "",
+		"password hashing algorithm overriding the configured one: bcrypt, argon2id or scrypt")
+
+	migrateCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		passwordConfig := config.NewPasswordDefault()
+		if configFilePath != "" {
+			serviceConfig, err := readConfiguration(configFilePath)
+			if err != nil {
+				return err
+			}
+			passwordConfig = serviceConfig.Password
+		}
+		if algorithm != "" {
+			passwordConfig.Algorithm = algorithm
+		}
+		hasher, err := passwordConfig.Hasher()
+		if err != nil {
+			return err
+		}
+
+		path := args[0]
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		migrated, count, err := repository.MigrateLocalConfig(data, hasher)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			if err = os.WriteFile(path, migrated, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		cmd.Printf("Hashed %d credentials in %s\n", count, path)
+		return nil
+	}
+	return migrateCmd
+}
+
 func readConfiguration(path string) (*config.Service, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 	config := config.NewServiceDefault()
-	err = yaml.Unmarshal(data, config)
-	return config, err
+	if err = yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, config.Validate()
+}
+
+// shutdownOnSignal gracefully shuts down the server on SIGINT or SIGTERM, and
+// sends the shutdown result to the channel.
+func shutdownOnSignal(server *http.Server, result chan<- error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	slog.Info("Shutting down service")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	result <- server.Shutdown(shutdownCtx)
+}
+
+// reloadKeysOnSignal reloads the keyring on SIGHUP. The keyring is retained if
+// the new signing key cannot be used with the signing method of the running server.
+func reloadKeysOnSignal(path string, keys *auth.Keys, signingMethod jwt.SigningMethod) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		config, err := readConfiguration(path)
+		if err != nil {
+			slog.Error("Failed to read configuration", "err", err)
+			continue
+		}
+		if err = keys.Reload(config.Secret, signingMethod); err != nil {
+			slog.Error("Failed to reload keys", "err", err)
+			continue
+		}
+		slog.Info("Keys reloaded", "kid", keys.KeyID())
+	}
 }
 
 func main() {
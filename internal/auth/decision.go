@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/reugn/auth-server/internal/repository"
+)
+
+// Reasons of the token validation failures, complementing the repository
+// decision reasons.
+const (
+	ReasonMissingToken      repository.Reason = "missing_token"
+	ReasonMalformedToken    repository.Reason = "malformed_token"
+	ReasonBadSignature      repository.Reason = "bad_signature"
+	ReasonUnverifiableToken repository.Reason = "unverifiable_token"
+	ReasonTokenExpired      repository.Reason = "token_expired"
+	ReasonTokenNotYetValid  repository.Reason = "token_not_yet_valid"
+	ReasonInvalidIssuer     repository.Reason = "invalid_issuer"
+	ReasonInvalidAudience   repository.Reason = "invalid_audience"
+	ReasonTokenRevoked      repository.Reason = "token_revoked"
+	ReasonInvalidToken      repository.Reason = "invalid_token"
+)
+
+// errMissingToken is the validation error of the requests without a token.
+var errMissingToken = errors.New("token is not specified")
+
+// Decision represents the result of the token authorization.
+type Decision struct {
+	repository.Decision
+	// Claims contains the claims of the valid token, nil if the token is invalid.
+	Claims *Claims
+	// Err is the token validation error.
+	Err error
+}
+
+// TokenValid reports whether the decision was made for a valid token.
+func (d *Decision) TokenValid() bool {
+	return d.Claims != nil
+}
+
+// newTokenDecision returns a denial decision for the token validation error.
+func newTokenDecision(err error) *Decision {
+	return &Decision{
+		Decision: repository.Decision{Reason: tokenErrorReason(err)},
+		Err:      err,
+	}
+}
+
+// tokenErrorReason returns the decision reason of the token validation error.
+func tokenErrorReason(err error) repository.Reason {
+	switch {
+	case errors.Is(err, errMissingToken):
+		return ReasonMissingToken
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ReasonMalformedToken
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return ReasonBadSignature
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ReasonUnverifiableToken
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ReasonTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ReasonTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return ReasonInvalidIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return ReasonInvalidAudience
+	case errors.Is(err, ErrTokenRevoked):
+		return ReasonTokenRevoked
+	default:
+		return ReasonInvalidToken
+	}
+}
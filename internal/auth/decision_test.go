@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/reugn/auth-server/internal/repository"
+)
+
+func TestJWTValidator_AuthorizeDecision(t *testing.T) {
+	keys := newTestKeys(t)
+	repo := newTestRepository(t, `
+roles:
+  viewer:
+    - method: GET
+      uri: /api/**
+    - method: DELETE
+      uri: /api/billing
+      effect: deny
+`)
+	claimsConfig := ClaimsConfig{Audience: []string{"api"}}
+	tokenGenerator := NewJWTGenerator(keys, jwt.SigningMethodRS256, claimsConfig)
+	tokenValidator := NewJWTValidator(keys, jwt.SigningMethodRS256, claimsConfig, repo,
+		NewMemoryRevocationStore())
+	userDetails := &repository.UserDetails{
+		UserName:  "user",
+		UserRoles: []repository.UserRole{"viewer"},
+	}
+
+	generate := func(generator *JWTGenerator) string {
+		token, err := generator.Generate(userDetails)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.Token
+	}
+	valid := generate(tokenGenerator)
+
+	expiredClaims := &Claims{Username: "user", Roles: userDetails.UserRoles}
+	expiredClaims.Audience = claimsConfig.Audience
+	expiredClaims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	expiredToken := jwt.NewWithClaims(jwt.SigningMethodRS256, expiredClaims)
+	expiredToken.Header["kid"] = keys.KeyID()
+	_, privateKey := keys.signingKey()
+	expired, err := expiredToken.SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeysGenerator := NewJWTGenerator(newTestKeys(t), jwt.SigningMethodRS256, claimsConfig)
+	otherAudienceGenerator := NewJWTGenerator(keys, jwt.SigningMethodRS256,
+		ClaimsConfig{Audience: []string{"other"}})
+
+	revoked := generate(tokenGenerator)
+	if err = tokenValidator.Revoke(revoked); err != nil {
+		t.Fatal(err)
+	}
+	signature := strings.LastIndex(valid, ".") + 1
+	tampered := valid[:signature] + strings.Repeat("A", len(valid)-signature)
+
+	tests := []struct {
+		name    string
+		token   string
+		method  string
+		uri     string
+		allowed bool
+		reason  repository.Reason
+		rule    bool
+	}{
+		{"allowed", valid, "GET", "/api/items", true, repository.ReasonRuleMatched, true},
+		{"explicit-deny", valid, "DELETE", "/api/billing", false, repository.ReasonExplicitDeny, true},
+		{"no-matching-rule", valid, "POST", "/api/items", false, repository.ReasonNoMatchingRule, false},
+		{"missing-token", "", "GET", "/api/items", false, ReasonMissingToken, false},
+		{"malformed-token", "token", "GET", "/api/items", false, ReasonMalformedToken, false},
+		{"bad-signature", tampered, "GET", "/api/items", false, ReasonBadSignature, false},
+		{"unknown-key", generate(otherKeysGenerator), "GET", "/api/items", false,
+			ReasonUnverifiableToken, false},
+		{"expired", expired, "GET", "/api/items", false, ReasonTokenExpired, false},
+		{"invalid-audience", generate(otherAudienceGenerator), "GET", "/api/items", false,
+			ReasonInvalidAudience, false},
+		{"revoked", revoked, "GET", "/api/items", false, ReasonTokenRevoked, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &repository.RequestDetails{Method: tt.method, URI: tt.uri}
+			decision := tokenValidator.Authorize(tt.token, request)
+			if decision.Allowed != tt.allowed || decision.Reason != tt.reason {
+				t.Fatalf("unexpected decision: %s, %v", decision.Decision, decision.Err)
+			}
+			if (decision.Rule != nil) != tt.rule {
+				t.Fatalf("unexpected decision rule: %s", decision.Decision)
+			}
+			if decision.TokenValid() != (decision.Err == nil) {
+				t.Fatal("token validity mismatch")
+			}
+			if decision.TokenValid() && decision.Role != "viewer" {
+				t.Fatalf("unexpected decision role: %s", decision.Role)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK represents a public JSON Web Key as defined in RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// RSA public key parameters.
+	Modulus  string `json:"n,omitempty"`
+	Exponent string `json:"e,omitempty"`
+	// Elliptic curve and Edwards-curve public key parameters.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKSet represents a JSON Web Key Set as defined in RFC 7517.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Marshal marshals the JWKSet to a JSON byte array.
+func (s *JWKSet) Marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// ecdsaAlgorithms maps the elliptic curves to the corresponding signing algorithms.
+var ecdsaAlgorithms = map[string]string{
+	"P-256": "ES256",
+	"P-384": "ES384",
+	"P-521": "ES512",
+}
+
+// newJWK creates a JWK from the public key.
+func newJWK(publicKey crypto.PublicKey) (JWK, error) {
+	var jwk JWK
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk = newRSAJWK(key)
+	case *ecdsa.PublicKey:
+		jwk = newECDSAJWK(key)
+	case ed25519.PublicKey:
+		jwk = newEd25519JWK(key)
+	default:
+		return jwk, fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+	jwk.Use = "sig"
+	jwk.KeyID = jwk.thumbprint()
+	return jwk, nil
+}
+
+func newRSAJWK(publicKey *rsa.PublicKey) JWK {
+	return JWK{
+		KeyType:  "RSA",
+		Modulus:  encodeBase64URL(publicKey.N.Bytes()),
+		Exponent: encodeBase64URL(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+func newECDSAJWK(publicKey *ecdsa.PublicKey) JWK {
+	// the coordinates must be the full size of the curve field
+	size := (publicKey.Curve.Params().BitSize + 7) / 8
+	return JWK{
+		KeyType:   "EC",
+		Algorithm: ecdsaAlgorithms[publicKey.Curve.Params().Name],
+		Curve:     publicKey.Curve.Params().Name,
+		X:         encodeBase64URL(publicKey.X.FillBytes(make([]byte, size))),
+		Y:         encodeBase64URL(publicKey.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func newEd25519JWK(publicKey ed25519.PublicKey) JWK {
+	return JWK{
+		KeyType:   "OKP",
+		Algorithm: "EdDSA",
+		Curve:     "Ed25519",
+		X:         encodeBase64URL(publicKey),
+	}
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the JWK.
+// The thumbprint is stable for the key and is used as the key identifier.
+func (jwk *JWK) thumbprint() string {
+	// the required members must be in lexicographic order with no whitespace
+	var canonical string
+	switch jwk.KeyType {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.Exponent, jwk.Modulus)
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Curve, jwk.X, jwk.Y)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Curve, jwk.X)
+	}
+	digest := sha256.Sum256([]byte(canonical))
+	return encodeBase64URL(digest[:])
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the public key.
+func thumbprint(publicKey crypto.PublicKey) (string, error) {
+	jwk, err := newJWK(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return jwk.KeyID, nil
+}
+
+func encodeBase64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestRSAThumbprint(t *testing.T) {
+	// the example key from RFC 7638, section 3.1
+	modulus := "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_" +
+		"BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_" +
+		"FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4" +
+		"vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	n, err := base64.RawURLEncoding.DecodeString(modulus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	jwk, err := newJWK(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk.KeyID != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("unexpected thumbprint: %s", jwk.KeyID)
+	}
+	if jwk.Modulus != modulus || jwk.Exponent != "AQAB" {
+		t.Fatal("unexpected key parameters")
+	}
+}
+
+func TestEd25519Thumbprint(t *testing.T) {
+	// the example key from RFC 8037, appendix A.3
+	x := "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"
+	publicKey, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := newJWK(ed25519.PublicKey(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk.KeyID != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Fatalf("unexpected thumbprint: %s", jwk.KeyID)
+	}
+	if jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.X != x {
+		t.Fatal("unexpected key parameters")
+	}
+}
+
+func TestECDSAJWK(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := newJWK(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk.KeyType != "EC" || jwk.Curve != "P-521" {
+		t.Fatal("unexpected key parameters")
+	}
+	// the coordinates are padded to the curve size
+	for _, coordinate := range []string{jwk.X, jwk.Y} {
+		decoded, err := base64.RawURLEncoding.DecodeString(coordinate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded) != 66 {
+			t.Fatalf("unexpected coordinate length: %d", len(decoded))
+		}
+	}
+}
+
+func TestKeys_JWKSet(t *testing.T) {
+	keys := newTestKeys(t)
+
+	data, err := keys.JWKSet().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwkSet := &JWKSet{}
+	if err = json.Unmarshal(data, jwkSet); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwkSet.Keys) != 1 {
+		t.Fatalf("unexpected number of keys: %d", len(jwkSet.Keys))
+	}
+	if jwkSet.Keys[0].KeyID != keys.KeyID() {
+		t.Fatal("key id mismatch")
+	}
+}
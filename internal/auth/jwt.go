@@ -3,6 +3,9 @@ package auth
 import (
 	"encoding/json"
 	"log/slog"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/reugn/auth-server/internal/repository"
@@ -30,15 +33,163 @@ func (t TokenType) String() string {
 // Claims is the custom JWT claims container.
 type Claims struct {
 	jwt.RegisteredClaims
-	Username string              `json:"user"`
-	Role     repository.UserRole `json:"role"`
+	Username string                `json:"user"`
+	Roles    []repository.UserRole `json:"roles"`
+	ClientID string                `json:"client_id,omitempty"`
+	Scope    string                `json:"scope,omitempty"`
+	// Extra contains the additional claims provided by the repository.
+	// The extra claims cannot override the claims above.
+	Extra map[string]any `json:"-"`
+}
+
+// legacyRoleClaim is the single role claim of the tokens issued by the previous versions.
+const legacyRoleClaim = "role"
+
+// reservedClaims contains the names of the claims which are not extra.
+var reservedClaims = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti",
+	"user", "roles", "client_id", "scope", legacyRoleClaim}
+
+// claimsFields is used to marshal and unmarshal the fields of Claims
+// without recursion.
+type claimsFields Claims
+
+// MarshalJSON implements the json.Marshaler interface.
+// The extra claims are merged into the top-level claims object. The first role
+// is also emitted as the single role claim for the consumers of the previously
+// issued tokens.
+func (c Claims) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		claimsFields
+		Role repository.UserRole `json:"role,omitempty"`
+	}{claimsFields(c), c.PrimaryRole()})
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+	merged := make(map[string]any)
+	if err = json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for name, value := range c.Extra {
+		if !slices.Contains(reservedClaims, name) {
+			merged[name] = value
+		}
+	}
+	return json.Marshal(merged)
+}
+
+// PrimaryRole returns the first role of the claims, or an empty role if none.
+func (c *Claims) PrimaryRole() repository.UserRole {
+	if len(c.Roles) == 0 {
+		return ""
+	}
+	return c.Roles[0]
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+// The unknown claims are collected into the extra claims.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	var fields claimsFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	*c = Claims(fields)
+
+	// support the single role claim of the previously issued tokens
+	if role, ok := all[legacyRoleClaim].(string); ok && role != "" && len(c.Roles) == 0 {
+		c.Roles = []repository.UserRole{repository.UserRole(role)}
+	}
+	for name, value := range all {
+		if !slices.Contains(reservedClaims, name) {
+			if c.Extra == nil {
+				c.Extra = make(map[string]any)
+			}
+			c.Extra[name] = value
+		}
+	}
+	return nil
+}
+
+// Map returns the claims as a map, including the extra claims.
+func (c *Claims) Map() (map[string]any, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]any)
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Value returns the string representation of the claim with the name.
+// The list claims are comma-separated, the non-string extra claims are
+// represented as JSON. The second return value reports whether the claim is set.
+func (c *Claims) Value(name string) (string, bool) {
+	var value string
+	switch name {
+	case "user":
+		value = c.Username
+	case legacyRoleClaim:
+		value = string(c.PrimaryRole())
+	case "roles":
+		roles := make([]string, len(c.Roles))
+		for i, role := range c.Roles {
+			roles[i] = string(role)
+		}
+		value = strings.Join(roles, ",")
+	case "client_id":
+		value = c.ClientID
+	case "scope":
+		value = c.Scope
+	case "iss":
+		value = c.Issuer
+	case "sub":
+		value = c.Subject
+	case "aud":
+		value = strings.Join(c.Audience, ",")
+	case "jti":
+		value = c.ID
+	default:
+		extra, ok := c.Extra[name]
+		if !ok || extra == nil {
+			return "", false
+		}
+		if str, ok := extra.(string); ok {
+			return str, true
+		}
+		data, err := json.Marshal(extra)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+	return value, value != ""
+}
+
+// ClaimsConfig contains the registered claims configuration, used to populate
+// the issued tokens and enforced when validating them.
+type ClaimsConfig struct {
+	// Issuer is the value of the iss claim. If empty, the claim is neither set nor enforced.
+	Issuer string
+	// Audience is the value of the aud claim. If not empty, a validated token
+	// must contain at least one of the audience values.
+	Audience []string
+	// Leeway is the allowed clock skew when validating the exp, nbf and iat claims.
+	Leeway time.Duration
 }
 
 // AccessToken represents an access token.
 type AccessToken struct {
-	Token   string `json:"access_token"`
-	Type    string `json:"token_type"`
-	Expires int64  `json:"expires_in"`
+	Token        string `json:"access_token"`
+	Type         string `json:"token_type"`
+	Expires      int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
 }
 
 // Marshal marshals the AccessToken to a JSON string.
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -16,38 +17,87 @@ const (
 type JWTGenerator struct {
 	keys             *Keys
 	signingMethod    jwt.SigningMethod
+	claimsConfig     ClaimsConfig
 	tokenExpireAfter time.Duration
 }
 
 // NewJWTGenerator returns a new instance of JWTGenerator.
-func NewJWTGenerator(keys *Keys, signingMethod jwt.SigningMethod) *JWTGenerator {
+func NewJWTGenerator(keys *Keys, signingMethod jwt.SigningMethod,
+	claimsConfig ClaimsConfig) *JWTGenerator {
 	tokenExpireAfter := time.Hour // default 1 hour
 	env.ReadTime(&tokenExpireAfter, envTokenExpireAfterMillis, time.Millisecond)
 	return &JWTGenerator{
 		keys:             keys,
 		signingMethod:    signingMethod,
+		claimsConfig:     claimsConfig,
 		tokenExpireAfter: tokenExpireAfter,
 	}
 }
 
-// Generate generates an AccessToken using the username and role claims.
-func (gen *JWTGenerator) Generate(username string, role repository.UserRole) (*AccessToken, error) {
-	token := jwt.New(gen.signingMethod)
+// Generate generates an AccessToken using the username, roles and the
+// additional claims of the user.
+func (gen *JWTGenerator) Generate(userDetails *repository.UserDetails) (*AccessToken, error) {
 	claims := Claims{}
 
 	// set custom claims
-	claims.Username = username
-	claims.Role = role
+	claims.Username = userDetails.UserName
+	claims.Roles = userDetails.UserRoles
+	claims.Extra = userDetails.Claims
+
+	claims.Subject = userDetails.UserName
+	return gen.generate(&claims)
+}
+
+// GenerateForClient generates an AccessToken for the client using the role
+// and the granted scopes claims.
+func (gen *JWTGenerator) GenerateForClient(client *repository.ClientDetails,
+	scopes []string) (*AccessToken, error) {
+	claims := Claims{}
+
+	// set custom claims
+	claims.ClientID = client.ClientID
+	if client.ClientRole != "" {
+		claims.Roles = []repository.UserRole{client.ClientRole}
+	}
+	claims.Scope = strings.Join(scopes, " ")
+
+	claims.Subject = client.ClientID
+	accessToken, err := gen.generate(&claims)
+	if err != nil {
+		return nil, err
+	}
+	accessToken.Scope = claims.Scope
+
+	return accessToken, nil
+}
+
+// generate sets the standard claims and signs the token.
+func (gen *JWTGenerator) generate(claims *Claims) (*AccessToken, error) {
+	token := jwt.New(gen.signingMethod)
 
 	// set standard claims
+	tokenID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	claims.ID = tokenID
+	claims.Issuer = gen.claimsConfig.Issuer
+	if len(gen.claimsConfig.Audience) > 0 {
+		claims.Audience = gen.claimsConfig.Audience
+	}
 	now := time.Now()
 	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
 	if gen.tokenExpireAfter > 0 {
 		claims.ExpiresAt = jwt.NewNumericDate(now.Add(gen.tokenExpireAfter))
 	}
 
-	token.Claims = &claims
-	signed, err := token.SignedString(gen.keys.privateKey)
+	// stamp the signing key id to select the verification key
+	keyID, privateKey := gen.keys.signingKey()
+	token.Header["kid"] = keyID
+
+	token.Claims = claims
+	signed, err := token.SignedString(privateKey)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +106,7 @@ func (gen *JWTGenerator) Generate(username string, role repository.UserRole) (*A
 	accessToken := &AccessToken{
 		Token:   signed,
 		Type:    BearerToken.String(),
-		Expires: gen.tokenExpireAfter.Milliseconds(),
+		Expires: int64(gen.tokenExpireAfter.Seconds()),
 	}
 
 	return accessToken, nil
@@ -1,17 +1,32 @@
 package auth
 
 import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/reugn/auth-server/internal/config"
 	"github.com/reugn/auth-server/internal/repository"
+	"github.com/reugn/auth-server/internal/util/hash"
 )
 
+var testUserDetails = &repository.UserDetails{
+	UserName:  "admin",
+	UserRoles: []repository.UserRole{"admin"},
+}
+
 func TestJWT_Authorize(t *testing.T) {
 	os.Setenv(repository.EnvLocalConfigPath, repository.DefaultLocalConfigPath)
-	repo, err := repository.NewLocal()
+	repo, err := repository.NewLocal(hash.NewDefaultHasher())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -23,8 +38,8 @@ func TestJWT_Authorize(t *testing.T) {
 	if err != nil {
 		t.Skip("keys are not available")
 	}
-	tokenGenerator := NewJWTGenerator(keys, jwt.SigningMethodRS256)
-	tokenValidator := NewJWTValidator(keys, repo)
+	tokenGenerator := NewJWTGenerator(keys, jwt.SigningMethodRS256, ClaimsConfig{})
+	tokenValidator := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{}, repo, NewMemoryRevocationStore())
 
 	tests := []struct {
 		name       string
@@ -84,14 +99,443 @@ func TestJWT_Authorize(t *testing.T) {
 					return
 				}
 			}
-			token, err := tokenGenerator.Generate(tt.username, userDetails.UserRole)
+			token, err := tokenGenerator.Generate(userDetails)
 			if err != nil {
 				t.Fatal(err)
 			}
-			authorized := tokenValidator.Authorize(token.Token, &tt.request)
+			authorized := tokenValidator.Authorize(token.Token, &tt.request).Allowed
 			if authorized != tt.authorized {
 				t.Fatal("authorization result mismatch")
 			}
 		})
 	}
 }
+
+func TestJWT_SigningMethods(t *testing.T) {
+	signingMethods := []jwt.SigningMethod{
+		jwt.SigningMethodRS256,
+		jwt.SigningMethodRS384,
+		jwt.SigningMethodRS512,
+		jwt.SigningMethodES256,
+		jwt.SigningMethodES384,
+		jwt.SigningMethodES512,
+		jwt.SigningMethodEdDSA,
+	}
+	for _, signingMethod := range signingMethods {
+		t.Run(signingMethod.Alg(), func(t *testing.T) {
+			keys := newTestKeysFor(t, signingMethod)
+			tokenGenerator := NewJWTGenerator(keys, signingMethod, ClaimsConfig{})
+			tokenValidator := NewJWTValidator(keys, signingMethod, ClaimsConfig{}, nil, NewMemoryRevocationStore())
+
+			token, err := tokenGenerator.Generate(testUserDetails)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := tokenValidator.validate(token.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Username != "admin" || !slices.Equal(claims.Roles, testUserDetails.UserRoles) {
+				t.Fatal("claims mismatch")
+			}
+
+			// a token signed by another key of the same type must be rejected
+			otherGenerator := NewJWTGenerator(newTestKeysFor(t, signingMethod), signingMethod, ClaimsConfig{})
+			otherToken, err := otherGenerator.Generate(testUserDetails)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = tokenValidator.validate(otherToken.Token); err == nil {
+				t.Fatal("token signed by an unknown key was accepted")
+			}
+		})
+	}
+}
+
+func TestJWT_AlgorithmConfusion(t *testing.T) {
+	rsaKey := generateTestKey(t)
+	ecdsaKey := generateTestKeyFor(t, jwt.SigningMethodES256)
+	keys := &Keys{}
+	// the retired ECDSA key is used to ensure a known key of another type is rejected
+	if err := keys.set(rsaKey, rsaKey.Public(), []crypto.PublicKey{ecdsaKey.Public()}); err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKeyID, err := thumbprint(ecdsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenValidator := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{}, nil, NewMemoryRevocationStore())
+
+	publicDer, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})
+
+	sign := func(signingMethod jwt.SigningMethod, keyID string, key interface{}) string {
+		t.Helper()
+		claims := &Claims{Username: "admin", Roles: testUserDetails.UserRoles}
+		claims.IssuedAt = jwt.NewNumericDate(time.Now())
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		token := jwt.NewWithClaims(signingMethod, claims)
+		token.Header["kid"] = keyID
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	valid := sign(jwt.SigningMethodRS256, keys.KeyID(), rsaKey)
+	if _, err := tokenValidator.validate(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	segments := strings.Split(valid, ".")
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"none", sign(jwt.SigningMethodNone, keys.KeyID(), jwt.UnsafeAllowNoneSignatureType)},
+		{"none-without-signature", strings.Join([]string{
+			encodeBase64URL([]byte(`{"alg":"none","typ":"JWT"}`)), segments[1], ""}, ".")},
+		{"hs256-public-key-pem", sign(jwt.SigningMethodHS256, keys.KeyID(), publicPem)},
+		{"hs256-public-key-der", sign(jwt.SigningMethodHS256, keys.KeyID(), publicDer)},
+		{"hs256-without-kid", sign(jwt.SigningMethodHS256, "", publicPem)},
+		{"rs384-same-key", sign(jwt.SigningMethodRS384, keys.KeyID(), rsaKey)},
+		{"ps256-same-key", sign(jwt.SigningMethodPS256, keys.KeyID(), rsaKey)},
+		{"es256-retired-key", sign(jwt.SigningMethodES256, ecdsaKeyID, ecdsaKey)},
+		{"alg-header-swap", strings.Join([]string{
+			encodeBase64URL([]byte(`{"alg":"RS384","kid":"` + keys.KeyID() + `","typ":"JWT"}`)),
+			segments[1], segments[2]}, ".")},
+		{"empty-alg", strings.Join([]string{
+			encodeBase64URL([]byte(`{"alg":"","typ":"JWT"}`)), segments[1], segments[2]}, ".")},
+		{"unknown-kid", sign(jwt.SigningMethodRS256, "unknown", rsaKey)},
+		{"stripped-signature", strings.Join(segments[:2], ".") + "."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tokenValidator.validate(tt.token); err == nil {
+				t.Fatal("token was accepted")
+			}
+		})
+	}
+}
+
+func TestJWT_RegisteredClaims(t *testing.T) {
+	keys := newTestKeys(t)
+	claimsConfig := ClaimsConfig{
+		Issuer:   "https://auth.example.com",
+		Audience: []string{"api", "web"},
+	}
+	tokenGenerator := NewJWTGenerator(keys, jwt.SigningMethodRS256, claimsConfig)
+	token, err := tokenGenerator.Generate(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newValidator := func(claimsConfig ClaimsConfig) *JWTValidator {
+		return NewJWTValidator(keys, jwt.SigningMethodRS256, claimsConfig, nil,
+			NewMemoryRevocationStore())
+	}
+	claims, err := newValidator(claimsConfig).validate(token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "admin" || claims.Issuer != claimsConfig.Issuer || claims.ID == "" ||
+		claims.NotBefore == nil || len(claims.Audience) != 2 {
+		t.Fatalf("unexpected registered claims: %+v", claims.RegisteredClaims)
+	}
+
+	invalidConfigs := map[string]ClaimsConfig{
+		"issuer-mismatch":   {Issuer: "https://other.example.com"},
+		"audience-mismatch": {Issuer: claimsConfig.Issuer, Audience: []string{"other"}},
+	}
+	for name, invalidConfig := range invalidConfigs {
+		if _, err = newValidator(invalidConfig).validate(token.Token); err == nil {
+			t.Fatalf("%s: token was accepted", name)
+		}
+	}
+	// a single matching audience is sufficient
+	if _, err = newValidator(ClaimsConfig{Audience: []string{"web"}}).validate(token.Token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJWT_ClockSkew(t *testing.T) {
+	keys := newTestKeys(t)
+	sign := func(notBefore time.Time, expiresAt time.Time) string {
+		t.Helper()
+		claims := &Claims{Username: "admin", Roles: testUserDetails.UserRoles}
+		claims.IssuedAt = jwt.NewNumericDate(time.Now())
+		claims.NotBefore = jwt.NewNumericDate(notBefore)
+		claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = keys.KeyID()
+		_, privateKey := keys.signingKey()
+		signed, err := token.SignedString(privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	now := time.Now()
+	notYetValid := sign(now.Add(30*time.Second), now.Add(time.Hour))
+	expired := sign(now.Add(-time.Hour), now.Add(-30*time.Second))
+
+	strict := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{}, nil,
+		NewMemoryRevocationStore())
+	lenient := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{Leeway: time.Minute},
+		nil, NewMemoryRevocationStore())
+	for _, token := range []string{notYetValid, expired} {
+		if _, err := strict.validate(token); err == nil {
+			t.Fatal("token was accepted without leeway")
+		}
+		if _, err := lenient.validate(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClaims_Extra(t *testing.T) {
+	claims := &Claims{
+		Username: "admin",
+		Roles:    []repository.UserRole{"admin", "viewer"},
+		Extra: map[string]any{
+			"tenant": "t1",
+			"email":  "admin@example.com",
+			"user":   "override",
+			"sub":    "override",
+		},
+	}
+	claims.Subject = "admin"
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]any
+	if err = json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	// the single role claim is kept for the existing consumers
+	if raw["role"] != "admin" {
+		t.Fatalf("unexpected role claim: %v", raw["role"])
+	}
+	parsed := &Claims{}
+	if err = json.Unmarshal(data, parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Username != "admin" || parsed.Subject != "admin" {
+		t.Fatal("extra claims must not override the reserved claims")
+	}
+	if !slices.Equal(parsed.Roles, claims.Roles) {
+		t.Fatalf("unexpected roles: %v", parsed.Roles)
+	}
+	if len(parsed.Extra) != 2 || parsed.Extra["tenant"] != "t1" ||
+		parsed.Extra["email"] != "admin@example.com" {
+		t.Fatalf("unexpected extra claims: %v", parsed.Extra)
+	}
+
+	// the single role claim of the previously issued tokens
+	legacy := &Claims{}
+	if err = json.Unmarshal([]byte(`{"user":"admin","role":"admin"}`), legacy); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(legacy.Roles, []repository.UserRole{"admin"}) || len(legacy.Extra) != 0 {
+		t.Fatalf("unexpected legacy claims: %+v", legacy)
+	}
+}
+
+// rolesRepository grants access to the configured URI per role.
+type rolesRepository map[repository.UserRole]string
+
+func (r rolesRepository) AuthenticateBasic(_ string, _ string) *repository.UserDetails {
+	return nil
+}
+
+func (r rolesRepository) LookupUser(_ string) (*repository.UserDetails, error) {
+	return nil, nil
+}
+
+func (r rolesRepository) AuthorizeRequest(userRole repository.UserRole,
+	request repository.RequestDetails) repository.Decision {
+	return repository.Decision{Allowed: r[userRole] == request.URI, Role: userRole}
+}
+
+func TestJWT_AuthorizeRoles(t *testing.T) {
+	keys := newTestKeys(t)
+	repo := rolesRepository{"viewer": "/dashboard", "editor": "/articles"}
+	tokenGenerator := NewJWTGenerator(keys, jwt.SigningMethodRS256, ClaimsConfig{})
+	tokenValidator := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{}, repo,
+		NewMemoryRevocationStore())
+
+	token, err := tokenGenerator.Generate(&repository.UserDetails{
+		UserName:  "editor",
+		UserRoles: []repository.UserRole{"viewer", "editor"},
+		Claims:    map[string]any{"tenant": "t1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for uri, expected := range map[string]bool{
+		"/dashboard": true,
+		"/articles":  true,
+		"/admin":     false,
+	} {
+		request := &repository.RequestDetails{Method: "GET", URI: uri}
+		if tokenValidator.Authorize(token.Token, request).Allowed != expected {
+			t.Fatalf("authorization result mismatch for %s", uri)
+		}
+	}
+
+	claims, err := tokenValidator.Validate(token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Extra["tenant"] != "t1" {
+		t.Fatal("extra claim is missing")
+	}
+}
+
+func TestClaims_Value(t *testing.T) {
+	claims := &Claims{
+		Username: "admin",
+		Roles:    []repository.UserRole{"admin", "viewer"},
+		Extra: map[string]any{
+			"email":  "admin@example.com",
+			"groups": []any{"a", "b"},
+		},
+	}
+	claims.Subject = "admin"
+
+	tests := []struct {
+		claim    string
+		expected string
+		ok       bool
+	}{
+		{"user", "admin", true},
+		{"sub", "admin", true},
+		{"roles", "admin,viewer", true},
+		{"role", "admin", true},
+		{"email", "admin@example.com", true},
+		{"groups", `["a","b"]`, true},
+		{"client_id", "", false},
+		{"unknown", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.claim, func(t *testing.T) {
+			value, ok := claims.Value(tt.claim)
+			if value != tt.expected || ok != tt.ok {
+				t.Fatalf("unexpected value: %q, %t", value, ok)
+			}
+		})
+	}
+}
+
+func TestJWT_AuthorizeDeny(t *testing.T) {
+	keys := newTestKeys(t)
+	repo := newTestRepository(t, `
+roles:
+  viewer:
+    - method: GET
+      uri: /api/**
+  auditor:
+    - method: "*"
+      uri: /api/billing
+      effect: deny
+    - method: GET
+      uri: /api/reports
+      effect: deny
+      condition: claims.user > 1
+`)
+	tokenGenerator := NewJWTGenerator(keys, jwt.SigningMethodRS256, ClaimsConfig{})
+	tokenValidator := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{}, repo,
+		NewMemoryRevocationStore())
+
+	token, err := tokenGenerator.Generate(&repository.UserDetails{
+		UserName:  "user",
+		UserRoles: []repository.UserRole{"viewer", "auditor"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an explicit deny of any of the roles wins
+	request := &repository.RequestDetails{Method: "GET", URI: "/api/billing"}
+	if tokenValidator.Authorize(token.Token, request).Allowed {
+		t.Fatal("denied request was authorized")
+	}
+	request = &repository.RequestDetails{Method: "GET", URI: "/api/items"}
+	if !tokenValidator.Authorize(token.Token, request).Allowed {
+		t.Fatal("request was not authorized")
+	}
+	// so does a condition error of any of the roles
+	request = &repository.RequestDetails{Method: "GET", URI: "/api/reports"}
+	decision := tokenValidator.Authorize(token.Token, request)
+	if decision.Allowed || decision.Reason != repository.ReasonConditionError {
+		t.Fatalf("unexpected decision: %s", decision.Decision)
+	}
+}
+
+func TestJWT_AuthorizeCondition(t *testing.T) {
+	keys := newTestKeys(t)
+	repo := newTestRepository(t, `
+roles:
+  member:
+    - method: GET
+      uri: /items
+      condition: claims.tenant == request.headers["x-tenant"] && "read" in claims.scopes
+`)
+	tokenGenerator := NewJWTGenerator(keys, jwt.SigningMethodRS256, ClaimsConfig{})
+	tokenValidator := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{}, repo,
+		NewMemoryRevocationStore())
+
+	token, err := tokenGenerator.Generate(&repository.UserDetails{
+		UserName:  "user",
+		UserRoles: []repository.UserRole{"member"},
+		Claims:    map[string]any{"tenant": "t1", "scopes": []string{"read"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tenant     string
+		authorized bool
+	}{
+		{"t1", true},
+		{"t2", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		request := &repository.RequestDetails{
+			Method:  "GET",
+			URI:     "/items",
+			Headers: http.Header{"X-Tenant": {tt.tenant}},
+		}
+		if tokenValidator.Authorize(token.Token, request).Allowed != tt.authorized {
+			t.Fatalf("authorization result mismatch for tenant %q", tt.tenant)
+		}
+		if request.Claims != nil {
+			t.Fatal("request details were modified")
+		}
+	}
+}
+
+// newTestRepository returns a local repository using the configuration.
+func newTestRepository(t *testing.T, config string) repository.Repository {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "local.yml")
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(repository.EnvLocalConfigPath, configPath)
+	repo, err := repository.NewLocal(hash.NewDefaultHasher())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
@@ -2,7 +2,10 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -11,23 +14,41 @@ import (
 
 // JWTValidator validates and authorizes an AccessToken.
 type JWTValidator struct {
-	keys    *Keys
-	backend repository.Repository
+	keys          *Keys
+	signingMethod jwt.SigningMethod
+	claimsConfig  ClaimsConfig
+	backend       repository.Repository
+	revocations   RevocationStore
 }
 
 // NewJWTValidator returns a new JWTValidator.
-func NewJWTValidator(keys *Keys, backend repository.Repository) *JWTValidator {
+// Only tokens signed using the signingMethod will be accepted.
+func NewJWTValidator(keys *Keys, signingMethod jwt.SigningMethod, claimsConfig ClaimsConfig,
+	backend repository.Repository, revocations RevocationStore) *JWTValidator {
 	return &JWTValidator{
-		keys:    keys,
-		backend: backend,
+		keys:          keys,
+		signingMethod: signingMethod,
+		claimsConfig:  claimsConfig,
+		backend:       backend,
+		revocations:   revocations,
 	}
 }
 
+// Validate validates the AccessToken and returns its claims.
+func (v *JWTValidator) Validate(token string) (*Claims, error) {
+	return v.validate(token)
+}
+
 // validate validates the AccessToken.
 func (v *JWTValidator) validate(jtwToken string) (*Claims, error) {
-	token, err := jwt.Parse(jtwToken, func(_ *jwt.Token) (interface{}, error) {
-		return v.keys.publicKey, nil
-	})
+	token, err := jwt.Parse(jtwToken, func(token *jwt.Token) (interface{}, error) {
+		// guard against algorithm confusion, in addition to the parser option
+		if token.Method != v.signingMethod {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		keyID, _ := token.Header["kid"].(string)
+		return v.keys.verificationKey(keyID)
+	}, v.parserOptions()...)
 	if err != nil {
 		return nil, err
 	}
@@ -35,21 +56,73 @@ func (v *JWTValidator) validate(jtwToken string) (*Claims, error) {
 	return v.validateClaims(token)
 }
 
+// parserOptions returns the options to validate the token signing method
+// and the registered claims.
+func (v *JWTValidator) parserOptions() []jwt.ParserOption {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{v.signingMethod.Alg()}),
+		jwt.WithLeeway(v.claimsConfig.Leeway),
+		jwt.WithIssuedAt(),
+	}
+	if v.claimsConfig.Issuer != "" {
+		options = append(options, jwt.WithIssuer(v.claimsConfig.Issuer))
+	}
+	return options
+}
+
 func (v *JWTValidator) validateClaims(token *jwt.Token) (*Claims, error) {
 	claims, err := getClaims(token)
 	if err != nil {
 		return nil, err
 	}
 
-	// validate expiration
-	if claims.ExpiresAt.Before(time.Now()) {
-		slog.Debug("Token expired")
-		return nil, jwt.ErrTokenExpired
+	// validate audience, the expiration and not-before are validated by the parser
+	if len(v.claimsConfig.Audience) > 0 && !containsAny(claims.Audience, v.claimsConfig.Audience) {
+		slog.Debug("Invalid token audience", "aud", claims.Audience)
+		return nil, jwt.ErrTokenInvalidAudience
+	}
+
+	// validate revocation
+	if claims.ID != "" {
+		revoked, err := v.revocations.IsRevoked(claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			slog.Debug("Token revoked", "jti", claims.ID)
+			return nil, ErrTokenRevoked
+		}
 	}
 
 	return claims, nil
 }
 
+// Revoke validates the token and adds it to the revocation list.
+func (v *JWTValidator) Revoke(token string) error {
+	claims, err := v.validate(token)
+	if err != nil {
+		return err
+	}
+	if claims.ID == "" {
+		return errors.New("token id is not specified")
+	}
+
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return v.revocations.Revoke(claims.ID, expiresAt)
+}
+
+func containsAny(values []string, expected []string) bool {
+	for _, value := range values {
+		if slices.Contains(expected, value) {
+			return true
+		}
+	}
+	return false
+}
+
 func getClaims(token *jwt.Token) (*Claims, error) {
 	mapClaims := token.Claims.(jwt.MapClaims)
 	jsonClaims, err := json.Marshal(mapClaims)
@@ -67,12 +140,53 @@ func getClaims(token *jwt.Token) (*Claims, error) {
 }
 
 // Authorize validates the token and authorizes the actual request.
-func (v *JWTValidator) Authorize(token string, request *repository.RequestDetails) bool {
+// It returns the decision explaining why the request was allowed or denied.
+func (v *JWTValidator) Authorize(token string, request *repository.RequestDetails) *Decision {
+	if token == "" {
+		return newTokenDecision(errMissingToken)
+	}
 	claims, err := v.validate(token)
 	if err != nil {
 		slog.Debug("Failed to authorize token", "err", err)
-		return false
+		return newTokenDecision(err)
 	}
 
-	return v.backend.AuthorizeRequest(claims.Role, *request)
+	// the conditions of the permissions are evaluated against the token claims
+	requestDetails := *request
+	if requestDetails.Claims, err = claims.Map(); err != nil {
+		slog.Debug("Failed to convert claims", "err", err)
+		return newTokenDecision(err)
+	}
+	if requestDetails.Time.IsZero() {
+		requestDetails.Time = time.Now()
+	}
+
+	decision := &Decision{
+		Decision: v.authorizeRoles(claims.Roles, &requestDetails),
+		Claims:   claims,
+	}
+	slog.Debug("Request authorization", "user", claims.Username, "request", request,
+		"decision", decision.Decision)
+	return decision
+}
+
+// authorizeRoles evaluates the request against each of the roles. An explicit deny
+// or an error of any role wins, otherwise the request is authorized if any of the
+// roles grants access.
+func (v *JWTValidator) authorizeRoles(roles []repository.UserRole,
+	request *repository.RequestDetails) repository.Decision {
+	decision := repository.Decision{Reason: repository.ReasonNoMatchingRule}
+	for _, role := range roles {
+		roleDecision := v.backend.AuthorizeRequest(role, *request)
+		switch {
+		case roleDecision.Reason == repository.ReasonExplicitDeny, roleDecision.Reason.Failed():
+			return roleDecision
+		case roleDecision.Allowed && !decision.Allowed:
+			decision = roleDecision
+		case !decision.Allowed && decision.Rule == nil && decision.Role == "":
+			// report the first evaluated role if none grants access
+			decision = roleDecision
+		}
+	}
+	return decision
 }
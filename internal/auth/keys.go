@@ -1,78 +1,311 @@
 package auth
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"os"
+	"sync"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/reugn/auth-server/internal/config"
 )
 
-// Keys represents a container for the private and public keys.
+// Keys represents a keyring, containing the active signing key pair and
+// the retired public keys still accepted for token verification.
 type Keys struct {
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
+	sync.RWMutex
+	privateKey crypto.Signer
+	keyID      string
+	// public keys by key id, including the active one
+	publicKeys map[string]crypto.PublicKey
+	// key ids in the order of publication, the active key goes first
+	keyIDs []string
 }
 
 // NewKeys returns a new instance of Keys.
 func NewKeys(config *config.Secret) (*Keys, error) {
-	return NewKeysFromFile(config.Private, config.Public)
+	priv, pub, retired, err := loadKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	keys := &Keys{}
+	if err = keys.set(priv, pub, retired); err != nil {
+		return nil, err
+	}
+	return keys, nil
 }
 
 // NewKeysFromFile creates and returns a new instance of Keys from the files
 // containing the secrets information.
 func NewKeysFromFile(privateKeyPath string, publicKeyPath string) (*Keys, error) {
-	priv, err := parsePrivateKey(&privateKeyPath, nil)
+	return NewKeys(&config.Secret{
+		Private: privateKeyPath,
+		Public:  publicKeyPath,
+	})
+}
+
+// NewKeysFromPem creates and returns a new instance of Keys from the pem byte arrays.
+func NewKeysFromPem(privatePem []byte, publicPem []byte) (*Keys, error) {
+	priv, err := parsePrivateKey(nil, privatePem)
 	if err != nil {
 		return nil, err
 	}
 
-	pub, err := parsePublicKey(&publicKeyPath, nil)
+	pub, err := parsePublicKey(nil, publicPem)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Keys{priv, pub}, nil
+	if err = checkKeyPair(priv, pub); err != nil {
+		return nil, err
+	}
+
+	keys := &Keys{}
+	if err = keys.set(priv, pub, nil); err != nil {
+		return nil, err
+	}
+	return keys, nil
 }
 
-// NewKeysFromPem creates and returns a new instance of Keys from the pem byte arrays.
-func NewKeysFromPem(privatePem []byte, publicPem []byte) (*Keys, error) {
-	priv, err := parsePrivateKey(nil, privatePem)
+// Reload reads the keyring from the files specified in the configuration and
+// replaces the current one. It is used to promote a new signing key without
+// restarting the service. The keyring is retained if the new signing key cannot
+// be used with the signing method.
+func (k *Keys) Reload(config *config.Secret, signingMethod jwt.SigningMethod) error {
+	priv, pub, retired, err := loadKeys(config)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err = validateSigningKey(priv, signingMethod); err != nil {
+		return err
 	}
+	return k.set(priv, pub, retired)
+}
 
-	pub, err := parsePublicKey(nil, publicPem)
+// loadKeys reads the signing key pair and the retired public keys from the files
+// specified in the configuration.
+func loadKeys(config *config.Secret) (crypto.Signer, crypto.PublicKey, []crypto.PublicKey, error) {
+	priv, err := parsePrivateKey(&config.Private, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+
+	pub, err := parsePublicKey(&config.Public, nil)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	return &Keys{priv, pub}, nil
+	if err = checkKeyPair(priv, pub); err != nil {
+		return nil, nil, nil, err
+	}
+
+	retired := make([]crypto.PublicKey, 0, len(config.Retired))
+	for i := range config.Retired {
+		retiredKey, err := parsePublicKey(&config.Retired[i], nil)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to parse retired key %s: %w", config.Retired[i], err)
+		}
+		retired = append(retired, retiredKey)
+	}
+	return priv, pub, retired, nil
 }
 
-func parsePrivateKey(privateKeyPath *string, pem []byte) (*rsa.PrivateKey, error) {
+// checkKeyPair verifies that the public key matches the private key.
+func checkKeyPair(privateKey crypto.Signer, publicKey crypto.PublicKey) error {
+	signerPublic, ok := privateKey.Public().(interface {
+		Equal(crypto.PublicKey) bool
+	})
+	if !ok || !signerPublic.Equal(publicKey) {
+		return errors.New("public key does not match the private key")
+	}
+	return nil
+}
+
+// set replaces the keyring contents.
+func (k *Keys) set(privateKey crypto.Signer, publicKey crypto.PublicKey,
+	retired []crypto.PublicKey) error {
+	keyID, err := thumbprint(publicKey)
+	if err != nil {
+		return err
+	}
+	publicKeys := map[string]crypto.PublicKey{keyID: publicKey}
+	keyIDs := []string{keyID}
+	for _, retiredKey := range retired {
+		retiredKeyID, err := thumbprint(retiredKey)
+		if err != nil {
+			return err
+		}
+		if _, ok := publicKeys[retiredKeyID]; ok {
+			continue
+		}
+		publicKeys[retiredKeyID] = retiredKey
+		keyIDs = append(keyIDs, retiredKeyID)
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	k.privateKey = privateKey
+	k.keyID = keyID
+	k.publicKeys = publicKeys
+	k.keyIDs = keyIDs
+	return nil
+}
+
+// KeyID returns the identifier of the active signing key.
+func (k *Keys) KeyID() string {
+	k.RLock()
+	defer k.RUnlock()
+
+	return k.keyID
+}
+
+// Validate verifies that the active signing key can be used with the signing method.
+func (k *Keys) Validate(signingMethod jwt.SigningMethod) error {
+	_, privateKey := k.signingKey()
+	return validateSigningKey(privateKey, signingMethod)
+}
+
+// validateSigningKey verifies that the private key can be used with the signing method.
+func validateSigningKey(privateKey crypto.Signer, signingMethod jwt.SigningMethod) error {
+	var valid bool
+	switch method := signingMethod.(type) {
+	case *jwt.SigningMethodRSA:
+		_, valid = privateKey.(*rsa.PrivateKey)
+	case *jwt.SigningMethodECDSA:
+		ecdsaKey, ok := privateKey.(*ecdsa.PrivateKey)
+		valid = ok && ecdsaKey.Curve.Params().BitSize == method.CurveBits
+	case *jwt.SigningMethodEd25519:
+		_, valid = privateKey.(ed25519.PrivateKey)
+	}
+	if !valid {
+		return fmt.Errorf("signing key of type %T is not valid for %s",
+			privateKey, signingMethod.Alg())
+	}
+	return nil
+}
+
+// signingKey returns the active signing key and its identifier.
+func (k *Keys) signingKey() (string, crypto.Signer) {
+	k.RLock()
+	defer k.RUnlock()
+
+	return k.keyID, k.privateKey
+}
+
+// verificationKey returns the public key by the key identifier.
+// Tokens issued without a key identifier are verified using the active key.
+func (k *Keys) verificationKey(keyID string) (crypto.PublicKey, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	if keyID == "" {
+		keyID = k.keyID
+	}
+	publicKey, ok := k.publicKeys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", keyID)
+	}
+	return publicKey, nil
+}
+
+// JWKSet returns the verification keys as a JSON Web Key Set.
+func (k *Keys) JWKSet() *JWKSet {
+	k.RLock()
+	defer k.RUnlock()
+
+	jwks := make([]JWK, 0, len(k.keyIDs))
+	for _, keyID := range k.keyIDs {
+		// the key types are validated when the keyring is loaded
+		jwk, _ := newJWK(k.publicKeys[keyID])
+		jwks = append(jwks, jwk)
+	}
+	return &JWKSet{
+		Keys: jwks,
+	}
+}
+
+func parsePrivateKey(privateKeyPath *string, pem []byte) (crypto.Signer, error) {
 	if privateKeyPath != nil {
 		pem, err := os.ReadFile(*privateKeyPath)
 		if err != nil {
 			return nil, err
 		}
-		return jwt.ParseRSAPrivateKeyFromPEM(pem)
+		return parsePrivateKeyPem(pem)
 	} else if pem != nil {
-		return jwt.ParseRSAPrivateKeyFromPEM(pem)
+		return parsePrivateKeyPem(pem)
 	}
 	return nil, errors.New("parsePrivateKey nil parameters")
 }
 
-func parsePublicKey(publicKeyPath *string, pem []byte) (*rsa.PublicKey, error) {
+func parsePublicKey(publicKeyPath *string, pem []byte) (crypto.PublicKey, error) {
 	if publicKeyPath != nil {
 		pem, err := os.ReadFile(*publicKeyPath)
 		if err != nil {
 			return nil, err
 		}
-		return jwt.ParseRSAPublicKeyFromPEM(pem)
+		return parsePublicKeyPem(pem)
 	} else if pem != nil {
-		return jwt.ParseRSAPublicKeyFromPEM(pem)
+		return parsePublicKeyPem(pem)
 	}
 	return nil, errors.New("parsePublicKey nil parameters")
 }
+
+// parsePrivateKeyPem parses an RSA, ECDSA or Ed25519 private key encoded
+// in the PKCS #1, SEC 1 or PKCS #8 form.
+func parsePrivateKeyPem(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key must be a PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		switch key := key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+			return key.(crypto.Signer), nil
+		default:
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("failed to parse private key")
+}
+
+// parsePublicKeyPem parses an RSA, ECDSA or Ed25519 public key encoded
+// in the PKIX or PKCS #1 form, or contained in an X.509 certificate.
+func parsePublicKeyPem(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key must be a PEM encoded")
+	}
+
+	var key crypto.PublicKey
+	if pkixKey, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		key = pkixKey
+	} else if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		key = cert.PublicKey
+	} else if rsaKey, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		key = rsaKey
+	} else {
+		return nil, errors.New("failed to parse public key")
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", key)
+	}
+}
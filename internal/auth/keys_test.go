@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/reugn/auth-server/internal/config"
+)
+
+func newTestKeys(t *testing.T) *Keys {
+	t.Helper()
+	return newTestKeysFor(t, jwt.SigningMethodRS256)
+}
+
+func newTestKeysFor(t *testing.T, signingMethod jwt.SigningMethod) *Keys {
+	t.Helper()
+	privateKey := generateTestKeyFor(t, signingMethod)
+	keys := &Keys{}
+	if err := keys.set(privateKey, privateKey.Public(), nil); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func generateTestKey(t *testing.T) crypto.Signer {
+	t.Helper()
+	return generateTestKeyFor(t, jwt.SigningMethodRS256)
+}
+
+func generateTestKeyFor(t *testing.T, signingMethod jwt.SigningMethod) crypto.Signer {
+	t.Helper()
+	var privateKey crypto.Signer
+	var err error
+	switch signingMethod {
+	case jwt.SigningMethodES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodES384:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case jwt.SigningMethodES512:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case jwt.SigningMethodEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey
+}
+
+// writeTestKeys writes the key pair to the temporary directory and returns
+// the paths to the private and public keys.
+func writeTestKeys(t *testing.T, privateKey crypto.Signer, name string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	privateDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath := filepath.Join(dir, name+"-privkey.pem")
+	publicPath := filepath.Join(dir, name+"-cert.pem")
+	writePem(t, privatePath, "PRIVATE KEY", privateDer)
+	writePem(t, publicPath, "PUBLIC KEY", publicDer)
+	return privatePath, publicPath
+}
+
+func writePem(t *testing.T, path string, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeys_Rotation(t *testing.T) {
+	oldPrivate, oldPublic := writeTestKeys(t, generateTestKey(t), "old")
+	newPrivate, newPublic := writeTestKeys(t, generateTestKey(t), "new")
+
+	keys, err := NewKeys(&config.Secret{Private: oldPrivate, Public: oldPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeyID := keys.KeyID()
+	generator := NewJWTGenerator(keys, jwt.SigningMethodRS256, ClaimsConfig{})
+	validator := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{}, nil, NewMemoryRevocationStore())
+
+	oldToken, err := generator.Generate(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// promote the new key, retaining the old one for verification
+	err = keys.Reload(&config.Secret{Private: newPrivate, Public: newPublic,
+		Retired: []string{oldPublic}}, jwt.SigningMethodRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.KeyID() == oldKeyID {
+		t.Fatal("signing key was not promoted")
+	}
+	if len(keys.JWKSet().Keys) != 2 {
+		t.Fatal("expected two verification keys")
+	}
+
+	newToken, err := generator.Generate(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken.Token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != keys.KeyID() {
+		t.Fatal("unexpected token key id")
+	}
+
+	for _, token := range []*AccessToken{oldToken, newToken} {
+		if _, err = validator.validate(token.Token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// drop the retired key
+	err = keys.Reload(&config.Secret{Private: newPrivate, Public: newPublic}, jwt.SigningMethodRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = validator.validate(oldToken.Token); err == nil {
+		t.Fatal("token signed by the dropped key was accepted")
+	}
+	if _, err = validator.validate(newToken.Token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeys_ReloadMismatch(t *testing.T) {
+	private, _ := writeTestKeys(t, generateTestKey(t), "first")
+	_, public := writeTestKeys(t, generateTestKey(t), "second")
+
+	if _, err := NewKeys(&config.Secret{Private: private, Public: public}); err == nil {
+		t.Fatal("expected key mismatch error")
+	}
+}
+
+func TestKeys_ReloadSigningMethodMismatch(t *testing.T) {
+	private, public := writeTestKeys(t, generateTestKey(t), "rsa")
+	keys, err := NewKeys(&config.Secret{Private: private, Public: public})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := keys.KeyID()
+	generator := NewJWTGenerator(keys, jwt.SigningMethodRS256, ClaimsConfig{})
+	validator := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{}, nil, NewMemoryRevocationStore())
+
+	for _, signingMethod := range []jwt.SigningMethod{jwt.SigningMethodES256, jwt.SigningMethodES384} {
+		ecPrivate, ecPublic := writeTestKeys(t, generateTestKeyFor(t, signingMethod), "ec")
+		if err = keys.Reload(&config.Secret{Private: ecPrivate, Public: ecPublic},
+			jwt.SigningMethodRS256); err == nil {
+			t.Fatal("expected signing method mismatch error")
+		}
+	}
+	// the P-256 key does not match ES384
+	ecPrivate, ecPublic := writeTestKeys(t, generateTestKeyFor(t, jwt.SigningMethodES256), "ec")
+	if err = keys.Reload(&config.Secret{Private: ecPrivate, Public: ecPublic},
+		jwt.SigningMethodES384); err == nil {
+		t.Fatal("expected curve mismatch error")
+	}
+
+	if keys.KeyID() != keyID {
+		t.Fatal("signing key was replaced")
+	}
+	token, err := generator.Generate(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = validator.validate(token.Token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeys_Parse(t *testing.T) {
+	signingMethods := []jwt.SigningMethod{
+		jwt.SigningMethodRS256,
+		jwt.SigningMethodES256,
+		jwt.SigningMethodES384,
+		jwt.SigningMethodES512,
+		jwt.SigningMethodEdDSA,
+	}
+	for _, signingMethod := range signingMethods {
+		t.Run(signingMethod.Alg(), func(t *testing.T) {
+			private, public := writeTestKeys(t, generateTestKeyFor(t, signingMethod), "key")
+			keys, err := NewKeysFromFile(private, public)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = keys.Validate(signingMethod); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestKeys_Validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		keyMethod     jwt.SigningMethod
+		signingMethod jwt.SigningMethod
+	}{
+		{"rsa-key-ecdsa-method", jwt.SigningMethodRS256, jwt.SigningMethodES256},
+		{"ecdsa-key-rsa-method", jwt.SigningMethodES256, jwt.SigningMethodRS256},
+		{"ecdsa-curve-mismatch", jwt.SigningMethodES256, jwt.SigningMethodES384},
+		{"ed25519-key-ecdsa-method", jwt.SigningMethodEdDSA, jwt.SigningMethodES256},
+		{"rsa-key-ed25519-method", jwt.SigningMethodRS256, jwt.SigningMethodEdDSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := newTestKeysFor(t, tt.keyMethod)
+			if err := keys.Validate(tt.signingMethod); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"container/list"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// LockoutConfig contains the account lockout configuration.
+type LockoutConfig struct {
+	// Threshold is the number of consecutive failed attempts locking the account,
+	// zero disables the lockout.
+	Threshold int
+	// Duration is the initial lockout duration, doubled on each failed attempt
+	// after the lockout has expired.
+	Duration time.Duration
+	// MaxDuration is the maximum lockout duration.
+	MaxDuration time.Duration
+	// ResetAfter is the period since the last failed attempt after which the
+	// failed attempts are forgotten.
+	ResetAfter time.Duration
+}
+
+// LockoutState contains the failed authentication attempts of an account.
+type LockoutState struct {
+	// Failures is the number of consecutive failed attempts, including the ones
+	// being verified.
+	Failures int
+	// LastFailure is the time of the last failed attempt.
+	LastFailure time.Time
+}
+
+// LockoutStore represents a storage for the failed authentication attempts per
+// username. A shared implementation enables lockout across multiple service instances.
+type LockoutStore interface {
+
+	// Get returns the failed attempts state of the username.
+	// A zero state is returned if there are no recorded failures.
+	Get(username string) (LockoutState, error)
+
+	// RecordFailure atomically increments the failed attempts of the username,
+	// setting the last failure time. The state is reset before incrementing if
+	// its last failure is more than ttl before the time of the failure.
+	RecordFailure(username string, at time.Time, ttl time.Duration) (LockoutState, error)
+
+	// Reset removes the failed attempts of the username.
+	Reset(username string) error
+}
+
+// Lockout tracks the failed authentication attempts per username and temporarily
+// locks the accounts using exponential backoff. The store failures are logged and
+// do not prevent the authentication.
+type Lockout struct {
+	config LockoutConfig
+	store  LockoutStore
+	now    func() time.Time
+}
+
+// NewLockout returns a new Lockout using the store.
+func NewLockout(config LockoutConfig, store LockoutStore) *Lockout {
+	return &Lockout{
+		config: config,
+		store:  store,
+		now:    time.Now,
+	}
+}
+
+// Attempt registers the authentication attempt of the username before verifying
+// the credentials, and returns the remaining lockout duration if the attempt is
+// rejected. The attempt is counted as failed in advance, so that the concurrent
+// attempts cannot exceed the threshold, and is forgotten by RecordSuccess.
+// Of the attempts following the expired lockout, only the first one is allowed.
+func (l *Lockout) Attempt(username string) time.Duration {
+	if l.config.Threshold < 1 {
+		return 0
+	}
+	previous, err := l.store.Get(username)
+	if err != nil {
+		slog.Error("Failed to read lockout state", "user", username, "err", err)
+		return 0
+	}
+	if remaining := l.remaining(previous); remaining > 0 {
+		return remaining
+	}
+	state, err := l.store.RecordFailure(username, l.now(), l.ttl())
+	if err != nil {
+		slog.Error("Failed to record authentication attempt", "user", username, "err", err)
+		return 0
+	}
+	if state.Failures <= max(l.config.Threshold, previous.Failures+1) {
+		return 0
+	}
+	// the concurrent attempts have reached the threshold
+	remaining := l.remaining(state)
+	slog.Warn("Account locked", "user", username, "failures", state.Failures,
+		"duration", remaining)
+	return remaining
+}
+
+// RecordSuccess resets the failed attempts of the username.
+func (l *Lockout) RecordSuccess(username string) {
+	if l.config.Threshold < 1 {
+		return
+	}
+	if err := l.store.Reset(username); err != nil {
+		slog.Error("Failed to reset lockout state", "user", username, "err", err)
+	}
+}
+
+// remaining returns the remaining lockout duration of the state.
+func (l *Lockout) remaining(state LockoutState) time.Duration {
+	if state.Failures < l.config.Threshold {
+		return 0
+	}
+	return max(state.LastFailure.Add(l.duration(state.Failures)).Sub(l.now()), 0)
+}
+
+// duration returns the lockout duration after the number of failures,
+// doubled for every failure above the threshold.
+func (l *Lockout) duration(failures int) time.Duration {
+	duration := l.config.Duration
+	for i := l.config.Threshold; i < failures && duration < l.config.MaxDuration; i++ {
+		duration *= 2
+	}
+	return min(duration, l.config.MaxDuration)
+}
+
+// ttl returns the time to keep the failed attempts state since the last failure.
+func (l *Lockout) ttl() time.Duration {
+	return max(l.config.ResetAfter, l.config.MaxDuration)
+}
+
+// lockoutEntry is the MemoryLockoutStore list element value.
+type lockoutEntry struct {
+	username  string
+	state     LockoutState
+	expiresAt time.Time
+}
+
+// MemoryLockoutStore implements the LockoutStore interface using an in-memory map.
+// The entries are pruned once expired, and the least recently failed ones are
+// evicted when the maximum number of entries is exceeded, e.g. by a username spray.
+type MemoryLockoutStore struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	// lru orders the entries by the last failure, the most recent in the front
+	lru        *list.List
+	maxEntries int
+	lastPruned time.Time
+}
+
+var _ LockoutStore = (*MemoryLockoutStore)(nil)
+
+// NewMemoryLockoutStore returns a new MemoryLockoutStore holding up to maxEntries
+// usernames.
+func NewMemoryLockoutStore(maxEntries int) *MemoryLockoutStore {
+	return &MemoryLockoutStore{
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: max(maxEntries, 1),
+		lastPruned: time.Now(),
+	}
+}
+
+// Get returns the failed attempts state of the username.
+func (s *MemoryLockoutStore) Get(username string) (LockoutState, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if element, ok := s.entries[username]; ok {
+		return element.Value.(*lockoutEntry).state, nil
+	}
+	return LockoutState{}, nil
+}
+
+// RecordFailure increments the failed attempts of the username.
+func (s *MemoryLockoutStore) RecordFailure(username string, at time.Time,
+	ttl time.Duration) (LockoutState, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.prune(at)
+	var entry *lockoutEntry
+	if element, ok := s.entries[username]; ok {
+		entry = element.Value.(*lockoutEntry)
+		s.lru.MoveToFront(element)
+		if at.After(entry.expiresAt) {
+			entry.state = LockoutState{}
+		}
+	} else {
+		entry = &lockoutEntry{username: username}
+		s.entries[username] = s.lru.PushFront(entry)
+		for s.lru.Len() > s.maxEntries {
+			s.remove(s.lru.Back())
+		}
+	}
+	entry.state.Failures++
+	entry.state.LastFailure = at
+	entry.expiresAt = at.Add(ttl)
+	return entry.state, nil
+}
+
+// Reset removes the failed attempts of the username.
+func (s *MemoryLockoutStore) Reset(username string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if element, ok := s.entries[username]; ok {
+		s.remove(element)
+	}
+	return nil
+}
+
+// Len returns the number of the stored usernames.
+func (s *MemoryLockoutStore) Len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lru.Len()
+}
+
+// prune removes the expired entries.
+func (s *MemoryLockoutStore) prune(now time.Time) {
+	if now.Sub(s.lastPruned) < memoryStorePruneInterval {
+		return
+	}
+	s.lastPruned = now
+	for _, element := range s.entries {
+		if now.After(element.Value.(*lockoutEntry).expiresAt) {
+			s.remove(element)
+		}
+	}
+}
+
+// remove removes the list element from the store.
+func (s *MemoryLockoutStore) remove(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.entries, element.Value.(*lockoutEntry).username)
+}
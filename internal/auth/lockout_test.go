@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// remainingLockout returns the remaining lockout duration of the username,
+// zero if the account is not locked.
+func remainingLockout(t *testing.T, lockout *Lockout, username string) time.Duration {
+	t.Helper()
+	state, err := lockout.store.Get(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lockout.remaining(state)
+}
+
+func TestLockout(t *testing.T) {
+	now := time.Now()
+	lockout := NewLockout(LockoutConfig{
+		Threshold:   3,
+		Duration:    time.Minute,
+		MaxDuration: 5 * time.Minute,
+		ResetAfter:  time.Hour,
+	}, NewMemoryLockoutStore(100))
+	lockout.now = func() time.Time { return now }
+
+	// the failed attempts are counted in advance
+	for i := 1; i <= 3; i++ {
+		if remainingLockout(t, lockout, "admin") != 0 {
+			t.Fatalf("locked after %d failures", i-1)
+		}
+		if duration := lockout.Attempt("admin"); duration != 0 {
+			t.Fatalf("attempt %d is rejected", i)
+		}
+	}
+
+	// the lockout duration is doubled for each failure after the lockout expires
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute,
+		5 * time.Minute, 5 * time.Minute} {
+		if remaining := remainingLockout(t, lockout, "admin"); remaining != expected {
+			t.Fatalf("unexpected remaining lockout: %s, expected: %s", remaining, expected)
+		}
+		// the rejected attempts do not extend the lockout
+		if duration := lockout.Attempt("admin"); duration != expected {
+			t.Fatalf("unexpected lockout duration: %s", duration)
+		}
+		if lockout.Attempt("user") != 0 {
+			t.Fatal("unrelated account is locked")
+		}
+		lockout.RecordSuccess("user")
+		now = now.Add(expected)
+		if remainingLockout(t, lockout, "admin") != 0 {
+			t.Fatal("lockout has not expired")
+		}
+		if lockout.Attempt("admin") != 0 {
+			t.Fatal("attempt after the expired lockout is rejected")
+		}
+	}
+
+	lockout.RecordSuccess("admin")
+	if remainingLockout(t, lockout, "admin") != 0 {
+		t.Fatal("failed attempts were not reset on success")
+	}
+
+	// the failed attempts are forgotten after the reset period
+	lockout.Attempt("admin")
+	lockout.Attempt("admin")
+	now = now.Add(time.Hour + time.Second)
+	lockout.Attempt("admin")
+	if lockout.Attempt("admin") != 0 || remainingLockout(t, lockout, "admin") != 0 {
+		t.Fatal("failed attempts were not reset after the reset period")
+	}
+}
+
+func TestLockout_ConcurrentAttempts(t *testing.T) {
+	now := time.Now()
+	lockout := NewLockout(LockoutConfig{
+		Threshold:   3,
+		Duration:    time.Minute,
+		MaxDuration: 5 * time.Minute,
+		ResetAfter:  time.Hour,
+	}, NewMemoryLockoutStore(100))
+	lockout.now = func() time.Time { return now }
+
+	countAllowed := func() int {
+		var allowed atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if lockout.Attempt("admin") == 0 {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		return int(allowed.Load())
+	}
+	if allowed := countAllowed(); allowed != 3 {
+		t.Fatalf("unexpected number of allowed attempts: %d", allowed)
+	}
+	now = now.Add(remainingLockout(t, lockout, "admin"))
+	if allowed := countAllowed(); allowed > 1 {
+		t.Fatalf("unexpected number of allowed attempts after the lockout: %d", allowed)
+	}
+}
+
+func TestLockout_Disabled(t *testing.T) {
+	store := NewMemoryLockoutStore(100)
+	lockout := NewLockout(LockoutConfig{}, store)
+	for i := 0; i < 10; i++ {
+		if lockout.Attempt("admin") != 0 {
+			t.Fatal("disabled lockout locked the account")
+		}
+	}
+	if store.Len() != 0 {
+		t.Fatal("disabled lockout recorded the attempts")
+	}
+}
+
+func TestMemoryLockoutStore_Evict(t *testing.T) {
+	store := NewMemoryLockoutStore(2)
+	now := time.Now()
+	for _, username := range []string{"first", "second", "first", "third"} {
+		if _, err := store.RecordFailure(username, now, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.Len() != 2 {
+		t.Fatalf("unexpected number of entries: %d", store.Len())
+	}
+	for username, expected := range map[string]int{"first": 2, "second": 0, "third": 1} {
+		state, err := store.Get(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Failures != expected {
+			t.Fatalf("unexpected failures of %s: %d", username, state.Failures)
+		}
+	}
+	if err := store.Reset("first"); err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("unexpected number of entries: %d", store.Len())
+	}
+}
+
+func TestMemoryLockoutStore_Prune(t *testing.T) {
+	store := NewMemoryLockoutStore(100)
+	now := time.Now()
+	if _, err := store.RecordFailure("expired", now.Add(-time.Hour), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RecordFailure("active", now, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	store.lastPruned = now.Add(-memoryStorePruneInterval)
+	if _, err := store.RecordFailure("new", now, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for username, expected := range map[string]bool{"expired": false, "active": true, "new": true} {
+		if _, ok := store.entries[username]; ok != expected {
+			t.Fatalf("unexpected state of %s: %t", username, ok)
+		}
+	}
+}
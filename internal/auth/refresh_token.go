@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/reugn/auth-server/internal/repository"
+	"github.com/reugn/auth-server/internal/util/env"
+	"github.com/reugn/auth-server/internal/util/hash"
+)
+
+const (
+	envRefreshTokenExpireAfterMillis = "AUTH_SERVER_REFRESH_TOKEN_EXPIRATION_MILLIS"
+)
+
+var (
+	// ErrRefreshTokenNotFound is returned when the refresh token is unknown.
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	// ErrRefreshTokenExpired is returned when the refresh token has expired.
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	// ErrRefreshTokenRevoked is returned when the refresh token family was revoked.
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	// ErrRefreshTokenReused is returned when a rotated refresh token is presented again.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrRefreshTokenUserNotFound is returned when the user of the refresh token
+	// no longer exists in the repository.
+	ErrRefreshTokenUserNotFound = errors.New("refresh token user not found")
+)
+
+// RefreshToken represents a stored refresh token record.
+// Each exchange rotates the refresh token, the tokens descending from
+// the same authentication form a family.
+type RefreshToken struct {
+	// ID is the hash of the refresh token value, the value itself is never stored.
+	ID string
+	// FamilyID identifies the family of the rotated tokens.
+	FamilyID string
+	// Username is the name of the authenticated user, whose details are
+	// reloaded from the repository on every exchange.
+	Username string
+	// ExpiresAt is the expiration time of the refresh token, inherited from
+	// the first token of the family, so that the rotation does not extend it.
+	ExpiresAt time.Time
+	// Used indicates whether the refresh token has already been exchanged.
+	Used bool
+}
+
+// RefreshTokenStore represents a storage for the refresh token families.
+type RefreshTokenStore interface {
+
+	// Get returns the refresh token record by id.
+	// It returns ErrRefreshTokenNotFound if the token is unknown and
+	// ErrRefreshTokenRevoked if the token family was revoked.
+	Get(id string) (*RefreshToken, error)
+
+	// Save stores a new refresh token record.
+	Save(token *RefreshToken) error
+
+	// Rotate marks the refresh token with the id as used and stores the
+	// replacement token atomically. It returns ErrRefreshTokenReused if the
+	// token has already been used.
+	Rotate(id string, replacement *RefreshToken) error
+
+	// RevokeFamily revokes all refresh tokens of the family.
+	RevokeFamily(familyID string) error
+}
+
+// RefreshTokenManager issues and exchanges refresh tokens.
+type RefreshTokenManager struct {
+	store       RefreshTokenStore
+	repository  repository.Repository
+	expireAfter time.Duration
+}
+
+// NewRefreshTokenManager returns a new RefreshTokenManager reloading the user
+// details from the repository on exchange.
+func NewRefreshTokenManager(store RefreshTokenStore, repository repository.Repository) *RefreshTokenManager {
+	expireAfter := 24 * time.Hour // default 24 hours
+	env.ReadTime(&expireAfter, envRefreshTokenExpireAfterMillis, time.Millisecond)
+	return &RefreshTokenManager{
+		store:       store,
+		repository:  repository,
+		expireAfter: expireAfter,
+	}
+}
+
+// Issue issues a refresh token starting a new token family for the user.
+func (m *RefreshTokenManager) Issue(userDetails *repository.UserDetails) (string, error) {
+	familyID, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	value, record, err := newRefreshToken(familyID, userDetails.UserName, time.Now().Add(m.expireAfter))
+	if err != nil {
+		return "", err
+	}
+	if err = m.store.Save(record); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// Exchange validates the refresh token and rotates it, returning the current
+// user details and the replacement refresh token. If a rotated token is presented
+// again, or the user no longer exists, the entire token family is revoked.
+func (m *RefreshTokenManager) Exchange(refreshToken string) (*repository.UserDetails, string, error) {
+	id := hash.Sha256(refreshToken)
+	record, err := m.store.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if record.Used {
+		return nil, "", m.revokeReused(record)
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, "", ErrRefreshTokenExpired
+	}
+
+	// the roles and the claims of the user may have changed since the authentication
+	userDetails, err := m.repository.LookupUser(record.Username)
+	if err != nil {
+		return nil, "", err
+	}
+	if userDetails == nil {
+		slog.Debug("Refresh token user not found, revoking the family",
+			"user", record.Username, "family", record.FamilyID)
+		if err = m.store.RevokeFamily(record.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenUserNotFound
+	}
+	value, replacement, err := newRefreshToken(record.FamilyID, record.Username, record.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = m.store.Rotate(id, replacement); err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			return nil, "", m.revokeReused(record)
+		}
+		return nil, "", err
+	}
+
+	return userDetails, value, nil
+}
+
+// Revoke revokes the family of the refresh token.
+func (m *RefreshTokenManager) Revoke(refreshToken string) error {
+	record, err := m.store.Get(hash.Sha256(refreshToken))
+	if err != nil {
+		return err
+	}
+	return m.store.RevokeFamily(record.FamilyID)
+}
+
+// revokeReused revokes the family of the reused refresh token.
+func (m *RefreshTokenManager) revokeReused(record *RefreshToken) error {
+	slog.Warn("Refresh token reuse detected, revoking the family",
+		"user", record.Username, "family", record.FamilyID)
+	if err := m.store.RevokeFamily(record.FamilyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// newRefreshToken generates a refresh token of the family, returning its value
+// and the record to store.
+func newRefreshToken(familyID string, username string, expiresAt time.Time) (string, *RefreshToken, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	value := base64.RawURLEncoding.EncodeToString(secret)
+	return value, &RefreshToken{
+		ID:        hash.Sha256(value),
+		FamilyID:  familyID,
+		Username:  username,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func randomHex(size int) (string, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
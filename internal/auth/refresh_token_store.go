@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const memoryStorePruneInterval = time.Minute
+
+// refreshTokenFamily contains the state of a refresh token family.
+type refreshTokenFamily struct {
+	id       string
+	tokenIDs []string
+	revoked  bool
+	// the latest expiration time of the tokens in the family
+	expiresAt time.Time
+}
+
+// MemoryRefreshTokenStore implements the RefreshTokenStore interface using
+// in-memory maps. The families are pruned once all their tokens have expired,
+// and the least recently used ones are evicted when the maximum number of
+// families is exceeded.
+type MemoryRefreshTokenStore struct {
+	sync.Mutex
+	tokens   map[string]*RefreshToken
+	families map[string]*list.Element
+	// lru orders the families by the last use, the most recent in the front
+	lru         *list.List
+	maxFamilies int
+	lastPruned  time.Time
+}
+
+var _ RefreshTokenStore = (*MemoryRefreshTokenStore)(nil)
+
+// NewMemoryRefreshTokenStore returns a new MemoryRefreshTokenStore holding up
+// to maxFamilies refresh token families.
+func NewMemoryRefreshTokenStore(maxFamilies int) *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{
+		tokens:      make(map[string]*RefreshToken),
+		families:    make(map[string]*list.Element),
+		lru:         list.New(),
+		maxFamilies: max(maxFamilies, 1),
+		lastPruned:  time.Now(),
+	}
+}
+
+// Get returns the refresh token record by id.
+func (s *MemoryRefreshTokenStore) Get(id string) (*RefreshToken, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if s.family(token.FamilyID).revoked {
+		return nil, ErrRefreshTokenRevoked
+	}
+	tokenCopy := *token
+	return &tokenCopy, nil
+}
+
+// Save stores a new refresh token record.
+func (s *MemoryRefreshTokenStore) Save(token *RefreshToken) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.prune()
+	s.add(token)
+	return nil
+}
+
+// Rotate marks the refresh token with the id as used and stores the
+// replacement token.
+func (s *MemoryRefreshTokenStore) Rotate(id string, replacement *RefreshToken) error {
+	s.Lock()
+	defer s.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return ErrRefreshTokenNotFound
+	}
+	if s.family(token.FamilyID).revoked {
+		return ErrRefreshTokenRevoked
+	}
+	if token.Used {
+		return ErrRefreshTokenReused
+	}
+	token.Used = true
+	s.add(replacement)
+	return nil
+}
+
+// RevokeFamily revokes all refresh tokens of the family.
+func (s *MemoryRefreshTokenStore) RevokeFamily(familyID string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if element, ok := s.families[familyID]; ok {
+		element.Value.(*refreshTokenFamily).revoked = true
+	}
+	return nil
+}
+
+// Len returns the number of the stored refresh token families.
+func (s *MemoryRefreshTokenStore) Len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lru.Len()
+}
+
+// family returns the family of a stored token.
+func (s *MemoryRefreshTokenStore) family(familyID string) *refreshTokenFamily {
+	return s.families[familyID].Value.(*refreshTokenFamily)
+}
+
+func (s *MemoryRefreshTokenStore) add(token *RefreshToken) {
+	tokenCopy := *token
+	s.tokens[token.ID] = &tokenCopy
+	var family *refreshTokenFamily
+	if element, ok := s.families[token.FamilyID]; ok {
+		family = element.Value.(*refreshTokenFamily)
+		s.lru.MoveToFront(element)
+	} else {
+		family = &refreshTokenFamily{id: token.FamilyID}
+		s.families[token.FamilyID] = s.lru.PushFront(family)
+		for s.lru.Len() > s.maxFamilies {
+			s.remove(s.lru.Back())
+		}
+	}
+	family.tokenIDs = append(family.tokenIDs, token.ID)
+	if token.ExpiresAt.After(family.expiresAt) {
+		family.expiresAt = token.ExpiresAt
+	}
+}
+
+// prune removes the families in which all tokens have expired.
+// The used tokens are retained until then to enable reuse detection.
+func (s *MemoryRefreshTokenStore) prune() {
+	now := time.Now()
+	if now.Sub(s.lastPruned) < memoryStorePruneInterval {
+		return
+	}
+	s.lastPruned = now
+	for _, element := range s.families {
+		if now.After(element.Value.(*refreshTokenFamily).expiresAt) {
+			s.remove(element)
+		}
+	}
+}
+
+// remove removes the family list element and the tokens of the family.
+func (s *MemoryRefreshTokenStore) remove(element *list.Element) {
+	family := s.lru.Remove(element).(*refreshTokenFamily)
+	for _, tokenID := range family.tokenIDs {
+		delete(s.tokens, tokenID)
+	}
+	delete(s.families, family.id)
+}
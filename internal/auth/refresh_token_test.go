@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/reugn/auth-server/internal/repository"
+	"github.com/reugn/auth-server/internal/util/hash"
+)
+
+// usersRepository looks up the configured user details by username.
+type usersRepository map[string]*repository.UserDetails
+
+func (r usersRepository) AuthenticateBasic(_ string, _ string) *repository.UserDetails {
+	return nil
+}
+
+func (r usersRepository) LookupUser(username string) (*repository.UserDetails, error) {
+	return r[username], nil
+}
+
+func (r usersRepository) AuthorizeRequest(userRole repository.UserRole,
+	_ repository.RequestDetails) repository.Decision {
+	return repository.Decision{Role: userRole}
+}
+
+func newTestRefreshTokenManager(maxFamilies int) (*RefreshTokenManager, usersRepository) {
+	users := usersRepository{testUserDetails.UserName: testUserDetails}
+	return NewRefreshTokenManager(NewMemoryRefreshTokenStore(maxFamilies), users), users
+}
+
+func TestRefreshTokenManager_Rotation(t *testing.T) {
+	manager, _ := newTestRefreshTokenManager(100)
+	userDetails := testUserDetails
+
+	first, err := manager.Issue(userDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exchanged, second, err := manager.Exchange(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exchanged, userDetails) {
+		t.Fatal("user details mismatch")
+	}
+	if second == first {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	third := exchangeRefreshToken(t, manager, second)
+
+	// presenting a rotated token again revokes the whole family
+	if _, _, err = manager.Exchange(first); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected reuse error, got: %v", err)
+	}
+	if _, _, err = manager.Exchange(third); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Fatalf("expected revoked error, got: %v", err)
+	}
+
+	// other families are not affected
+	other, err := manager.Issue(userDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchangeRefreshToken(t, manager, other)
+}
+
+func TestRefreshTokenManager_Invalid(t *testing.T) {
+	manager, _ := newTestRefreshTokenManager(100)
+	if _, _, err := manager.Exchange("unknown"); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+
+	manager.expireAfter = -time.Second
+	expired, err := manager.Issue(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = manager.Exchange(expired); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Fatalf("expected expired error, got: %v", err)
+	}
+}
+
+func TestRefreshTokenManager_FamilyLifetime(t *testing.T) {
+	manager, _ := newTestRefreshTokenManager(100)
+	refreshToken, err := manager.Issue(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := manager.store.Get(hash.Sha256(refreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the rotation does not extend the lifetime of the family
+	time.Sleep(10 * time.Millisecond)
+	refreshToken = exchangeRefreshToken(t, manager, refreshToken)
+	rotated, err := manager.store.Get(hash.Sha256(refreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.ExpiresAt.Equal(first.ExpiresAt) {
+		t.Fatalf("family lifetime is extended: %s, %s", first.ExpiresAt, rotated.ExpiresAt)
+	}
+}
+
+func TestRefreshTokenManager_ReloadUser(t *testing.T) {
+	manager, users := newTestRefreshTokenManager(100)
+	refreshToken, err := manager.Issue(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the exchange reflects the current roles and claims of the user
+	updated := &repository.UserDetails{
+		UserName:  testUserDetails.UserName,
+		UserRoles: []repository.UserRole{"viewer"},
+		Claims:    map[string]any{"tenant": "t2"},
+	}
+	users[updated.UserName] = updated
+	userDetails, refreshToken, err := manager.Exchange(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(userDetails, updated) {
+		t.Fatalf("user details are not reloaded: %+v", userDetails)
+	}
+
+	// the family of the deleted user is revoked
+	delete(users, updated.UserName)
+	if _, _, err = manager.Exchange(refreshToken); !errors.Is(err, ErrRefreshTokenUserNotFound) {
+		t.Fatalf("expected user not found error, got: %v", err)
+	}
+	users[updated.UserName] = updated
+	if _, _, err = manager.Exchange(refreshToken); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Fatalf("expected revoked error, got: %v", err)
+	}
+}
+
+func TestMemoryRefreshTokenStore_Evict(t *testing.T) {
+	manager, _ := newTestRefreshTokenManager(2)
+	oldest, err := manager.Issue(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := manager.Issue(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rotation marks the family as recently used
+	oldest = exchangeRefreshToken(t, manager, oldest)
+	if _, err = manager.Issue(testUserDetails); err != nil {
+		t.Fatal(err)
+	}
+
+	store := manager.store.(*MemoryRefreshTokenStore)
+	if store.Len() != 2 {
+		t.Fatalf("unexpected number of families: %d", store.Len())
+	}
+	if _, _, err = manager.Exchange(used); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Fatalf("least recently used family is not evicted: %v", err)
+	}
+	exchangeRefreshToken(t, manager, oldest)
+}
+
+func exchangeRefreshToken(t *testing.T, manager *RefreshTokenManager, refreshToken string) string {
+	t.Helper()
+	_, rotated, err := manager.Exchange(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rotated
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTokenRevoked is returned when the token has been revoked.
+var ErrTokenRevoked = errors.New("token revoked")
+
+// RevocationStore represents a storage for the revoked token identifiers.
+// A shared implementation enables revocation across multiple service instances.
+type RevocationStore interface {
+
+	// Revoke adds the token id to the revocation list. The entry may be
+	// discarded after expiresAt, since the token is no longer valid anyway.
+	// A zero expiresAt denotes a token that never expires.
+	Revoke(tokenID string, expiresAt time.Time) error
+
+	// IsRevoked checks if the token id is in the revocation list.
+	IsRevoked(tokenID string) (bool, error)
+}
+
+// MemoryRevocationStore implements the RevocationStore interface using
+// an in-memory map. The entries are pruned once the tokens have expired.
+type MemoryRevocationStore struct {
+	sync.RWMutex
+	revoked    map[string]time.Time
+	lastPruned time.Time
+}
+
+var _ RevocationStore = (*MemoryRevocationStore)(nil)
+
+// NewMemoryRevocationStore returns a new MemoryRevocationStore.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		revoked:    make(map[string]time.Time),
+		lastPruned: time.Now(),
+	}
+}
+
+// Revoke adds the token id to the revocation list.
+func (s *MemoryRevocationStore) Revoke(tokenID string, expiresAt time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.prune()
+	s.revoked[tokenID] = expiresAt
+	return nil
+}
+
+// IsRevoked checks if the token id is in the revocation list.
+func (s *MemoryRevocationStore) IsRevoked(tokenID string) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	_, ok := s.revoked[tokenID]
+	return ok, nil
+}
+
+// prune removes the entries of the expired tokens.
+func (s *MemoryRevocationStore) prune() {
+	now := time.Now()
+	if now.Sub(s.lastPruned) < memoryStorePruneInterval {
+		return
+	}
+	s.lastPruned = now
+	for tokenID, expiresAt := range s.revoked {
+		if !expiresAt.IsZero() && now.After(expiresAt) {
+			delete(s.revoked, tokenID)
+		}
+	}
+}
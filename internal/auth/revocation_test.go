@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTValidator_Revoke(t *testing.T) {
+	keys := newTestKeys(t)
+	tokenGenerator := NewJWTGenerator(keys, jwt.SigningMethodRS256, ClaimsConfig{})
+	tokenValidator := NewJWTValidator(keys, jwt.SigningMethodRS256, ClaimsConfig{}, nil, NewMemoryRevocationStore())
+
+	revoked, err := tokenGenerator.Generate(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := tokenGenerator.Generate(testUserDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tokenValidator.Revoke(revoked.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tokenValidator.validate(revoked.Token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("expected revoked error, got: %v", err)
+	}
+	// the tokens are identified by the jti claim
+	if _, err = tokenValidator.validate(active.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tokenValidator.Revoke("invalid"); err == nil {
+		t.Fatal("invalid token was revoked")
+	}
+}
+
+func TestMemoryRevocationStore_Prune(t *testing.T) {
+	store := NewMemoryRevocationStore()
+	now := time.Now()
+	for tokenID, expiresAt := range map[string]time.Time{
+		"expired":  now.Add(-time.Minute),
+		"active":   now.Add(time.Hour),
+		"infinite": {},
+	} {
+		if err := store.Revoke(tokenID, expiresAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.lastPruned = now.Add(-memoryStorePruneInterval)
+	if err := store.Revoke("new", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for tokenID, expected := range map[string]bool{
+		"expired":  false,
+		"active":   true,
+		"infinite": true,
+		"new":      true,
+	} {
+		revoked, err := store.IsRevoked(tokenID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != expected {
+			t.Fatalf("unexpected revocation status for %s", tokenID)
+		}
+	}
+}
@@ -3,6 +3,10 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
 )
 
 // HTTP contains HTTP server configuration properties.
@@ -13,6 +17,16 @@ type HTTP struct {
 	Port int `yaml:"port,omitempty" json:"port,omitempty"`
 	// Rate limiter configuration.
 	Rate RateLimiter `yaml:"rate,omitempty" json:"rate,omitempty"`
+	// Account lockout configuration.
+	Lockout Lockout `yaml:"lockout,omitempty" json:"lockout,omitempty"`
+	// Refresh token configuration.
+	RefreshToken RefreshToken `yaml:"refresh-token,omitempty" json:"refresh-token,omitempty"`
+	// TrustedProxies is the list of the proxy IP addresses and CIDR networks
+	// trusted to report the client IP address in the forwarding headers.
+	TrustedProxies []string `yaml:"trusted-proxies,omitempty" json:"trusted-proxies,omitempty"`
+	// AuthResponseHeaders maps the response header names to the token claims,
+	// set on the successfully authorized /auth requests to be forwarded by the proxy.
+	AuthResponseHeaders map[string]string `yaml:"auth-response-headers,omitempty" json:"auth-response-headers,omitempty"`
 }
 
 // RateLimiter contains rate limiter configuration properties.
@@ -21,8 +35,115 @@ type RateLimiter struct {
 	Tps int `yaml:"tps,omitempty" json:"tps,omitempty"`
 	// Rate limiter token bucket size (bursts threshold).
 	Size int `yaml:"size,omitempty" json:"size,omitempty"`
-	// A list of IP addresses to exclude from rate limiting.
+	// A list of IP addresses to exclude from the IP based rate limiting.
 	WhiteList []string `yaml:"white-list,omitempty" json:"white-list,omitempty"`
+	// The idle period after which the rate limiter of an IP address is evicted.
+	// It should exceed the time to refill the bucket, size / tps.
+	TTL time.Duration `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+	// The maximum number of the tracked IP addresses, the least recently used
+	// ones are evicted when exceeded.
+	MaxEntries int `yaml:"max-entries,omitempty" json:"max-entries,omitempty"`
+	// Policies are the additional per-route rate limits, applied along with
+	// the default per-IP limit.
+	Policies []RateLimitPolicy `yaml:"policies,omitempty" json:"policies,omitempty"`
+	// Store is the rate limiter state store.
+	Store RateLimitStore `yaml:"store,omitempty" json:"store,omitempty"`
+}
+
+// Rate limiter store types.
+const (
+	// RateLimitStoreLocal keeps the rate limiter state in memory of each service instance.
+	RateLimitStoreLocal = "local"
+	// RateLimitStoreAerospike shares the rate limiter state by the service instances
+	// using Aerospike Database.
+	RateLimitStoreAerospike = "aerospike"
+)
+
+// RateLimitStore contains the rate limiter store configuration properties.
+type RateLimitStore struct {
+	// The store type: local or aerospike.
+	Type string `yaml:"type" json:"type"`
+	// The Aerospike host.
+	Host string `yaml:"host,omitempty" json:"host,omitempty"`
+	// The Aerospike port.
+	Port int `yaml:"port,omitempty" json:"port,omitempty"`
+	// The Aerospike namespace.
+	Namespace string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	// The Aerospike set name of the rate limiter records.
+	Set string `yaml:"set,omitempty" json:"set,omitempty"`
+}
+
+func (c *RateLimitStore) validate() error {
+	switch c.Type {
+	case RateLimitStoreLocal:
+		return nil
+	case RateLimitStoreAerospike:
+		if c.Host == "" {
+			return errors.New("rate limit store host is not specified")
+		}
+		if c.Port < 1 {
+			return fmt.Errorf("invalid rate limit store port: %d", c.Port)
+		}
+		if c.Namespace == "" || c.Set == "" {
+			return errors.New("rate limit store namespace and set must be specified")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid rate limit store type: %q", c.Type)
+	}
+}
+
+// Rate limit policy key types, identifying the rate limited caller.
+const (
+	// RateLimitKeyIP limits the requests by the client IP address.
+	RateLimitKeyIP = "ip"
+	// RateLimitKeyUsername limits the requests by the basic authentication
+	// username or the client id of the client authentication.
+	RateLimitKeyUsername = "username"
+	// RateLimitKeySubject limits the requests by the subject of a valid bearer token.
+	RateLimitKeySubject = "subject"
+	// RateLimitKeyHeader limits the requests by the value of a header.
+	RateLimitKeyHeader = "header"
+)
+
+var validRateLimitKeys = []string{RateLimitKeyIP, RateLimitKeyUsername,
+	RateLimitKeySubject, RateLimitKeyHeader}
+
+// RateLimitPolicy contains the rate limit policy configuration properties.
+type RateLimitPolicy struct {
+	// The request path to apply the policy to, "*" applies to all routes.
+	Route string `yaml:"route" json:"route"`
+	// The key type: ip, username, subject or header.
+	Key string `yaml:"key" json:"key"`
+	// The header name for the header key type.
+	Header string `yaml:"header,omitempty" json:"header,omitempty"`
+	// Tokens per second threshold, may be fractional, e.g. 0.1 for one request per 10 seconds.
+	Tps float64 `yaml:"tps" json:"tps"`
+	// Token bucket size (bursts threshold).
+	Size int `yaml:"size" json:"size"`
+}
+
+func (c *RateLimitPolicy) validate() error {
+	if c.Route != "*" && (!strings.HasPrefix(c.Route, "/") || strings.ContainsAny(c.Route, " \t")) {
+		return fmt.Errorf("invalid rate limit policy route: %q", c.Route)
+	}
+	if !slices.Contains(validRateLimitKeys, c.Key) {
+		return fmt.Errorf("invalid rate limit policy key: %q", c.Key)
+	}
+	if strings.ContainsAny(c.Header, " \t:") {
+		return fmt.Errorf("invalid rate limit policy header: %q", c.Header)
+	}
+	if (c.Key == RateLimitKeyHeader) != (c.Header != "") {
+		return fmt.Errorf("rate limit policy header must be specified only for the header key: %s",
+			c.Route)
+	}
+	if c.Tps <= 0 {
+		return fmt.Errorf("invalid rate limit policy tps: %g", c.Tps)
+	}
+	if c.Size < 1 {
+		return fmt.Errorf("invalid rate limit policy size: %d", c.Size)
+	}
+	return nil
 }
 
 func (c *RateLimiter) validate() error {
@@ -35,6 +156,77 @@ func (c *RateLimiter) validate() error {
 	if c.Size < 1 {
 		return fmt.Errorf("invalid rate size: %d", c.Size)
 	}
+	if c.TTL <= 0 {
+		return fmt.Errorf("invalid rate ttl: %s", c.TTL)
+	}
+	if c.MaxEntries < 1 {
+		return fmt.Errorf("invalid rate max entries: %d", c.MaxEntries)
+	}
+	type policyID struct{ route, key, header string }
+	policies := make(map[policyID]bool, len(c.Policies))
+	for i := range c.Policies {
+		policy := &c.Policies[i]
+		if err := policy.validate(); err != nil {
+			return err
+		}
+		id := policyID{policy.Route, policy.Key, policy.Header}
+		if policies[id] {
+			return fmt.Errorf("duplicate rate limit policy: %s %s %s", policy.Route, policy.Key, policy.Header)
+		}
+		policies[id] = true
+	}
+	return c.Store.validate()
+}
+
+// Lockout contains account lockout configuration properties, protecting the basic
+// authentication of the token route against password guessing.
+type Lockout struct {
+	// The number of consecutive failed attempts locking the account, 0 disables the lockout.
+	Threshold int `yaml:"threshold" json:"threshold"`
+	// The initial lockout duration, doubled on each failed attempt after the lockout expires.
+	Duration time.Duration `yaml:"duration,omitempty" json:"duration,omitempty"`
+	// The maximum lockout duration.
+	MaxDuration time.Duration `yaml:"max-duration,omitempty" json:"max-duration,omitempty"`
+	// The period since the last failed attempt after which the failed attempts are forgotten.
+	ResetAfter time.Duration `yaml:"reset-after,omitempty" json:"reset-after,omitempty"`
+	// The maximum number of the tracked usernames, the least recently failed ones
+	// are evicted when exceeded.
+	MaxEntries int `yaml:"max-entries,omitempty" json:"max-entries,omitempty"`
+}
+
+func (c *Lockout) validate() error {
+	if c.Threshold < 0 {
+		return fmt.Errorf("invalid lockout threshold: %d", c.Threshold)
+	}
+	if c.Threshold == 0 {
+		return nil
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("invalid lockout duration: %s", c.Duration)
+	}
+	if c.MaxDuration < c.Duration {
+		return fmt.Errorf("lockout max duration %s is less than duration %s", c.MaxDuration, c.Duration)
+	}
+	if c.ResetAfter <= 0 {
+		return fmt.Errorf("invalid lockout reset period: %s", c.ResetAfter)
+	}
+	if c.MaxEntries < 1 {
+		return fmt.Errorf("invalid lockout max entries: %d", c.MaxEntries)
+	}
+	return nil
+}
+
+// RefreshToken contains refresh token configuration properties.
+type RefreshToken struct {
+	// The maximum number of the stored refresh token families, the least recently
+	// used ones are evicted when exceeded.
+	MaxEntries int `yaml:"max-entries,omitempty" json:"max-entries,omitempty"`
+}
+
+func (c *RefreshToken) validate() error {
+	if c.MaxEntries < 1 {
+		return fmt.Errorf("invalid refresh token max entries: %d", c.MaxEntries)
+	}
 	return nil
 }
 
@@ -44,9 +236,28 @@ func NewHTTPDefault() *HTTP {
 		Host: "0.0.0.0",
 		Port: 8080,
 		Rate: RateLimiter{
-			Tps:       1024,
-			Size:      1024,
-			WhiteList: []string{},
+			Tps:        1024,
+			Size:       1024,
+			WhiteList:  []string{},
+			TTL:        10 * time.Minute,
+			MaxEntries: 100000,
+			Store: RateLimitStore{
+				Type:      RateLimitStoreLocal,
+				Host:      "localhost",
+				Port:      3000,
+				Namespace: "test",
+				Set:       "rate_limit",
+			},
+		},
+		Lockout: Lockout{
+			Threshold:   5,
+			Duration:    30 * time.Second,
+			MaxDuration: 15 * time.Minute,
+			ResetAfter:  time.Hour,
+			MaxEntries:  100000,
+		},
+		RefreshToken: RefreshToken{
+			MaxEntries: 100000,
 		},
 	}
 }
@@ -65,5 +276,26 @@ func (c *HTTP) validate() error {
 	if err := c.Rate.validate(); err != nil {
 		return err
 	}
+	if err := c.Lockout.validate(); err != nil {
+		return err
+	}
+	if err := c.RefreshToken.validate(); err != nil {
+		return err
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err = netip.ParseAddr(proxy); err != nil {
+				return fmt.Errorf("invalid trusted proxy: %q", proxy)
+			}
+		}
+	}
+	for header, claim := range c.AuthResponseHeaders {
+		if header == "" || strings.ContainsAny(header, " \t\r\n:") {
+			return fmt.Errorf("invalid auth response header name: %q", header)
+		}
+		if claim == "" {
+			return fmt.Errorf("auth response header claim is not specified: %s", header)
+		}
+	}
 	return nil
 }
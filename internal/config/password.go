@@ -0,0 +1,98 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/reugn/auth-server/internal/util/hash"
+)
+
+// Password contains the password hashing configuration properties, used to hash
+// the passwords and to upgrade the outdated hashes on successful authentication.
+type Password struct {
+	// Algorithm is one of bcrypt, argon2id or scrypt.
+	Algorithm string `yaml:"algorithm,omitempty" json:"algorithm,omitempty"`
+	// Bcrypt algorithm parameters.
+	Bcrypt Bcrypt `yaml:"bcrypt,omitempty" json:"bcrypt,omitempty"`
+	// Argon2id algorithm parameters.
+	Argon2id Argon2id `yaml:"argon2id,omitempty" json:"argon2id,omitempty"`
+	// Scrypt algorithm parameters.
+	Scrypt Scrypt `yaml:"scrypt,omitempty" json:"scrypt,omitempty"`
+}
+
+// Bcrypt contains the bcrypt parameters.
+type Bcrypt struct {
+	// The cost factor.
+	Cost int `yaml:"cost,omitempty" json:"cost,omitempty"`
+}
+
+// Argon2id contains the argon2id parameters.
+type Argon2id struct {
+	// The memory size in KiB.
+	Memory uint32 `yaml:"memory,omitempty" json:"memory,omitempty"`
+	// The number of iterations.
+	Time uint32 `yaml:"time,omitempty" json:"time,omitempty"`
+	// The degree of parallelism.
+	Threads uint8 `yaml:"threads,omitempty" json:"threads,omitempty"`
+}
+
+// Scrypt contains the scrypt parameters.
+type Scrypt struct {
+	// The base 2 logarithm of the CPU/memory cost.
+	LogN int `yaml:"ln,omitempty" json:"ln,omitempty"`
+	// The block size.
+	R int `yaml:"r,omitempty" json:"r,omitempty"`
+	// The parallelization factor.
+	P int `yaml:"p,omitempty" json:"p,omitempty"`
+}
+
+// NewPasswordDefault returns a new Password config with default values.
+func NewPasswordDefault() *Password {
+	params := hash.DefaultPasswordParams()
+	return &Password{
+		Algorithm: params.Algorithm,
+		Bcrypt:    Bcrypt{Cost: params.BcryptCost},
+		Argon2id: Argon2id{
+			Memory:  params.Argon2Memory,
+			Time:    params.Argon2Time,
+			Threads: params.Argon2Threads,
+		},
+		Scrypt: Scrypt{
+			LogN: params.ScryptLogN,
+			R:    params.ScryptR,
+			P:    params.ScryptP,
+		},
+	}
+}
+
+// params returns the password hashing parameters.
+func (c *Password) params() hash.PasswordParams {
+	return hash.PasswordParams{
+		Algorithm:     strings.ToLower(c.Algorithm),
+		BcryptCost:    c.Bcrypt.Cost,
+		Argon2Memory:  c.Argon2id.Memory,
+		Argon2Time:    c.Argon2id.Time,
+		Argon2Threads: c.Argon2id.Threads,
+		ScryptLogN:    c.Scrypt.LogN,
+		ScryptR:       c.Scrypt.R,
+		ScryptP:       c.Scrypt.P,
+	}
+}
+
+// Hasher returns the configured password hasher.
+func (c *Password) Hasher() (hash.PasswordHasher, error) {
+	return hash.NewHasher(c.params())
+}
+
+// validate validates the Password configuration properties.
+func (c *Password) validate() error {
+	if c == nil {
+		return errors.New("password config is nil")
+	}
+	params := c.params()
+	if err := params.Validate(); err != nil {
+		return fmt.Errorf("invalid password config: %w", err)
+	}
+	return nil
+}
@@ -10,6 +10,9 @@ type Secret struct {
 	Private string `yaml:"private-path,omitempty" json:"private-path,omitempty"`
 	// Public denotes the path to the public key.
 	Public string `yaml:"public-path,omitempty" json:"public-path,omitempty"`
+	// Retired denotes the paths to the public keys of the retired signing keys.
+	// Tokens signed by these keys are still accepted until they expire.
+	Retired []string `yaml:"retired-public-paths,omitempty" json:"retired-public-paths,omitempty"`
 }
 
 // NewSecretDefault returns a new Secret with default values.
@@ -31,5 +34,10 @@ func (s *Secret) validate() error {
 	if s.Public == "" {
 		return errors.New("public key path is not specified")
 	}
+	for _, retired := range s.Retired {
+		if retired == "" {
+			return errors.New("retired public key path is empty")
+		}
+	}
 	return nil
 }
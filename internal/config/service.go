@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/reugn/auth-server/internal/proxy"
@@ -17,18 +19,33 @@ const (
 	signingMethodRS256 = "RS256"
 	signingMethodRS384 = "RS384"
 	signingMethodRS512 = "RS512"
+	signingMethodES256 = "ES256"
+	signingMethodES384 = "ES384"
+	signingMethodES512 = "ES512"
+	signingMethodEdDSA = "EDDSA"
 )
 
-var validSigningMethods = []string{signingMethodRS256, signingMethodRS384, signingMethodRS512}
+var validSigningMethods = []string{signingMethodRS256, signingMethodRS384, signingMethodRS512,
+	signingMethodES256, signingMethodES384, signingMethodES512, signingMethodEdDSA}
 
 // Service contains the entire service configuration.
 type Service struct {
-	SigningMethod      string  `yaml:"signing-method,omitempty" json:"signing-method,omitempty"`
-	ProxyProvider      string  `yaml:"proxy,omitempty" json:"proxy,omitempty"`
-	RepositoryProvider string  `yaml:"repository,omitempty" json:"repository,omitempty"`
-	HTTP               *HTTP   `yaml:"http,omitempty" json:"http,omitempty"`
-	Secret             *Secret `yaml:"secret,omitempty" json:"secret,omitempty"`
-	Logger             *Logger `yaml:"logger,omitempty" json:"logger,omitempty"`
+	// Issuer is the base URL of the service, set as the iss claim of the issued tokens
+	// and enforced on validation. The discovery documents are published only if
+	// the issuer is specified, otherwise the claim is omitted.
+	Issuer string `yaml:"issuer,omitempty" json:"issuer,omitempty"`
+	// Audience is the list of the intended token recipients, set as the aud claim.
+	// A validated token must contain at least one of the values.
+	Audience []string `yaml:"audience,omitempty" json:"audience,omitempty"`
+	// ClockSkew is the allowed clock skew when validating the time based token claims.
+	ClockSkew          time.Duration `yaml:"clock-skew,omitempty" json:"clock-skew,omitempty"`
+	SigningMethod      string        `yaml:"signing-method,omitempty" json:"signing-method,omitempty"`
+	ProxyProvider      string        `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	RepositoryProvider string        `yaml:"repository,omitempty" json:"repository,omitempty"`
+	HTTP               *HTTP         `yaml:"http,omitempty" json:"http,omitempty"`
+	Secret             *Secret       `yaml:"secret,omitempty" json:"secret,omitempty"`
+	Password           *Password     `yaml:"password,omitempty" json:"password,omitempty"`
+	Logger             *Logger       `yaml:"logger,omitempty" json:"logger,omitempty"`
 }
 
 // NewServiceDefault returns a new Service config with default values.
@@ -39,23 +56,33 @@ func NewServiceDefault() *Service {
 		RepositoryProvider: "local",
 		HTTP:               NewHTTPDefault(),
 		Secret:             NewSecretDefault(),
+		Password:           NewPasswordDefault(),
 		Logger:             NewLoggerDefault(),
 	}
 }
 
-func (c *Service) SigningMethodRSA() (*jwt.SigningMethodRSA, error) {
-	var signingMethodRSA *jwt.SigningMethodRSA
+// JWTSigningMethod returns the configured token signing method.
+func (c *Service) JWTSigningMethod() (jwt.SigningMethod, error) {
+	var signingMethod jwt.SigningMethod
 	switch strings.ToUpper(c.SigningMethod) {
 	case signingMethodRS256:
-		signingMethodRSA = jwt.SigningMethodRS256
+		signingMethod = jwt.SigningMethodRS256
 	case signingMethodRS384:
-		signingMethodRSA = jwt.SigningMethodRS384
+		signingMethod = jwt.SigningMethodRS384
 	case signingMethodRS512:
-		signingMethodRSA = jwt.SigningMethodRS512
+		signingMethod = jwt.SigningMethodRS512
+	case signingMethodES256:
+		signingMethod = jwt.SigningMethodES256
+	case signingMethodES384:
+		signingMethod = jwt.SigningMethodES384
+	case signingMethodES512:
+		signingMethod = jwt.SigningMethodES512
+	case signingMethodEdDSA:
+		signingMethod = jwt.SigningMethodEdDSA
 	default:
 		return nil, fmt.Errorf("unsupported signing method: %s", c.SigningMethod)
 	}
-	return signingMethodRSA, nil
+	return signingMethod, nil
 }
 
 func (c *Service) RequestParser() (proxy.RequestParser, error) {
@@ -72,13 +99,17 @@ func (c *Service) RequestParser() (proxy.RequestParser, error) {
 }
 
 func (c *Service) Repository() (repository.Repository, error) {
+	hasher, err := c.Password.Hasher()
+	if err != nil {
+		return nil, err
+	}
 	switch strings.ToLower(c.RepositoryProvider) {
 	case "local":
-		return repository.NewLocal()
+		return repository.NewLocal(hasher)
 	case "aerospike":
-		return repository.NewAerospike()
+		return repository.NewAerospike(hasher)
 	case "vault":
-		return repository.NewVault()
+		return repository.NewVault(hasher)
 	default:
 		return nil, fmt.Errorf("unsupported storage provider: %s", c.RepositoryProvider)
 	}
@@ -89,6 +120,19 @@ func (c *Service) Validate() error {
 	if c == nil {
 		return errors.New("service config is nil")
 	}
+	if c.Issuer != "" {
+		issuer, err := url.Parse(c.Issuer)
+		if err != nil {
+			return fmt.Errorf("invalid issuer: %w", err)
+		}
+		if !issuer.IsAbs() || issuer.RawQuery != "" || issuer.Fragment != "" {
+			return fmt.Errorf("issuer must be an absolute URL without query and fragment: %s",
+				c.Issuer)
+		}
+	}
+	if c.ClockSkew < 0 {
+		return fmt.Errorf("invalid clock skew: %s", c.ClockSkew)
+	}
 	if !slices.Contains(validSigningMethods, strings.ToUpper(c.SigningMethod)) {
 		return fmt.Errorf("invalid signing method: %s", c.SigningMethod)
 	}
@@ -104,6 +148,9 @@ func (c *Service) Validate() error {
 	if err := c.Secret.validate(); err != nil {
 		return err
 	}
+	if err := c.Password.validate(); err != nil {
+		return err
+	}
 	if err := c.Logger.validate(); err != nil {
 		return err
 	}
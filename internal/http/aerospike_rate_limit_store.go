@@ -0,0 +1,82 @@
+package http
+
+import (
+	"math"
+	"time"
+
+	as "github.com/aerospike/aerospike-client-go/v7"
+	"github.com/aerospike/aerospike-client-go/v7/types"
+)
+
+// aerospikeRateLimitBin is the bin name of the rate limiter state value.
+const aerospikeRateLimitBin = "value"
+
+// AerospikeRateLimitStore implements the RateLimitStore interface using Aerospike
+// Database, versioning the values by the record generation.
+type AerospikeRateLimitStore struct {
+	client    *as.Client
+	namespace string
+	set       string
+}
+
+var _ RateLimitStore = (*AerospikeRateLimitStore)(nil)
+
+// NewAerospikeRateLimitStore returns a new AerospikeRateLimitStore keeping
+// the records in the namespace set.
+func NewAerospikeRateLimitStore(host string, port int, namespace, set string) (*AerospikeRateLimitStore, error) {
+	client, err := as.NewClient(host, port)
+	if err != nil {
+		return nil, err
+	}
+	return &AerospikeRateLimitStore{
+		client:    client,
+		namespace: namespace,
+		set:       set,
+	}, nil
+}
+
+// Get implements the RateLimitStore interface.
+func (s *AerospikeRateLimitStore) Get(key string) (int64, uint32, error) {
+	asKey, err := as.NewKey(s.namespace, s.set, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	record, asErr := s.client.Get(nil, asKey, aerospikeRateLimitBin)
+	if asErr != nil {
+		if asErr.Matches(types.KEY_NOT_FOUND_ERROR) {
+			return 0, 0, nil
+		}
+		return 0, 0, asErr
+	}
+	value, _ := record.Bins[aerospikeRateLimitBin].(int)
+	return int64(value), record.Generation, nil
+}
+
+// CompareAndSet implements the RateLimitStore interface. The record is created
+// for the zero version, and the ttl is rounded up to seconds.
+func (s *AerospikeRateLimitStore) CompareAndSet(key string, value int64, version uint32,
+	ttl time.Duration) (bool, error) {
+	asKey, err := as.NewKey(s.namespace, s.set, key)
+	if err != nil {
+		return false, err
+	}
+	policy := as.NewWritePolicy(version, uint32(max(math.Ceil(ttl.Seconds()), 1)))
+	if version == 0 {
+		policy.RecordExistsAction = as.CREATE_ONLY
+	} else {
+		policy.GenerationPolicy = as.EXPECT_GEN_EQUAL
+	}
+	asErr := s.client.Put(policy, asKey, as.BinMap{aerospikeRateLimitBin: value})
+	if asErr != nil {
+		if asErr.Matches(types.GENERATION_ERROR, types.KEY_EXISTS_ERROR) {
+			return false, nil
+		}
+		return false, asErr
+	}
+	return true, nil
+}
+
+// Close closes the Aerospike client.
+func (s *AerospikeRateLimitStore) Close() {
+	s.client.Close()
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIPResolver resolves the client IP address of the requests. The forwarding
+// headers are only taken into account if the request is received from a trusted proxy.
+type ClientIPResolver struct {
+	trustedProxies []netip.Prefix
+}
+
+// NewClientIPResolver returns a new ClientIPResolver trusting the proxies
+// specified by the IP addresses and CIDR networks.
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	prefixes := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", proxy)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return &ClientIPResolver{trustedProxies: prefixes}, nil
+}
+
+// ClientIP returns the client IP address of the request. If the request is received
+// from a trusted proxy, the address is resolved from the first present header of
+// Forwarded, X-Forwarded-For and X-Real-IP, walking the forwarding chain from the
+// closest hop while the hops are trusted proxies.
+func (c *ClientIPResolver) ClientIP(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return "", err
+	}
+	clientIP := remote.Unmap()
+	if !c.isTrusted(clientIP) {
+		return clientIP.String(), nil
+	}
+
+	hops := forwardedHops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// the unknown and obfuscated identifiers break the chain
+			break
+		}
+		clientIP = hop.Unmap()
+		if !c.isTrusted(clientIP) {
+			break
+		}
+	}
+	return clientIP.String(), nil
+}
+
+// isTrusted reports whether the address belongs to a trusted proxy.
+func (c *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range c.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHops returns the addresses of the forwarding chain from the first present
+// header of Forwarded, X-Forwarded-For and X-Real-IP, ordered from the client to
+// the closest hop. The multiple header lines are concatenated in order.
+func forwardedHops(header http.Header) []string {
+	var hops []string
+	if forwarded := header.Values("Forwarded"); len(forwarded) > 0 {
+		for _, element := range splitHeaderList(forwarded) {
+			hops = append(hops, forwardedFor(element))
+		}
+		return hops
+	}
+	if forwardedFor := header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+		return splitHeaderList(forwardedFor)
+	}
+	if realIP := strings.TrimSpace(header.Get("X-Real-IP")); realIP != "" {
+		return []string{realIP}
+	}
+	return nil
+}
+
+// splitHeaderList splits the comma-separated header values.
+func splitHeaderList(values []string) []string {
+	var elements []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			elements = append(elements, strings.TrimSpace(element))
+		}
+	}
+	return elements
+}
+
+// forwardedFor returns the address of the for parameter of the Forwarded header
+// element as defined in RFC 7239, without the port. It returns the identifier as is
+// if it is not an address, e.g. "unknown".
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.EqualFold(name, "for") {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		if strings.HasPrefix(value, "[") {
+			// an IPv6 address, optionally followed by the port
+			if end := strings.Index(value, "]"); end > 0 {
+				return value[1:end]
+			}
+			return value
+		}
+		if host, _, err := net.SplitHostPort(value); err == nil {
+			return host
+		}
+		return value
+	}
+	return ""
+}
+
+// clientIPKey is the request context key of the resolved client IP address.
+type clientIPKey struct{}
+
+// withClientIP returns the request with the client IP address set in the context.
+func withClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// clientIP returns the client IP address resolved by the middleware.
+func clientIP(r *http.Request) string {
+	ip, _ := r.Context().Value(clientIPKey{}).(string)
+	return ip
+}
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/reugn/auth-server/internal/config"
+)
+
+func TestClientIPResolver_ClientIP(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    http.Header
+		expected   string
+	}{
+		{"direct", "203.0.113.1:1234", nil, "203.0.113.1"},
+		{"untrusted-remote", "203.0.113.1:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.1"},
+		{"trusted-without-headers", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"x-forwarded-for", "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"x-forwarded-for-chain", "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"x-forwarded-for-lines", "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1", "10.0.0.2"}}, "198.51.100.1"},
+		{"x-forwarded-for-all-trusted", "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"x-forwarded-for-invalid", "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, invalid, 10.0.0.2"}}, "10.0.0.2"},
+		{"x-real-ip", "192.0.2.1:1234",
+			http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+		{"forwarded", "10.0.0.1:1234",
+			http.Header{"Forwarded": {`for=198.51.100.1;proto=https, for="10.0.0.2:8080"`}}, "198.51.100.1"},
+		{"forwarded-ipv6", "[2001:db8::1]:1234",
+			http.Header{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"forwarded-unknown", "10.0.0.1:1234",
+			http.Header{"Forwarded": {"for=unknown"}}, "10.0.0.1"},
+		{"forwarded-precedence", "10.0.0.1:1234", http.Header{
+			"Forwarded":       {"for=198.51.100.1"},
+			"X-Forwarded-For": {"198.51.100.2"},
+		}, "198.51.100.1"},
+		{"ipv4-mapped", "[::ffff:10.0.0.1]:1234",
+			http.Header{"X-Forwarded-For": {"::ffff:198.51.100.1"}}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = tt.remoteAddr
+			request.Header = tt.headers
+			ip, err := resolver.ClientIP(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ip != tt.expected {
+				t.Fatalf("unexpected client ip: %s", ip)
+			}
+		})
+	}
+}
+
+func TestNewClientIPResolver_Invalid(t *testing.T) {
+	if _, err := NewClientIPResolver([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("expected an invalid proxy error")
+	}
+}
+
+func TestServer_TrustedProxies(t *testing.T) {
+	server := newTestServer(t, func(serviceConfig *config.Service) {
+		serviceConfig.HTTP.TrustedProxies = []string{"10.0.0.0/8"}
+		serviceConfig.HTTP.Rate.WhiteList = []string{"198.51.100.1"}
+		serviceConfig.HTTP.Rate.Policies = []config.RateLimitPolicy{
+			{Route: "/health", Key: config.RateLimitKeyIP, Tps: 0.1, Size: 1},
+		}
+	})
+	health := func(forwardedFor string) int {
+		request := httptest.NewRequest(http.MethodGet, "/health", nil)
+		request.RemoteAddr = "10.0.0.1:1234"
+		request.Header.Set("X-Forwarded-For", forwardedFor)
+		return serve(server, request).StatusCode
+	}
+
+	// the clients behind the proxy are limited independently
+	if health("198.51.100.2") != http.StatusOK || health("198.51.100.3") != http.StatusOK {
+		t.Fatal("clients behind the proxy share the rate limit")
+	}
+	if health("198.51.100.2") != http.StatusTooManyRequests {
+		t.Fatal("client behind the proxy is not rate limited")
+	}
+	// the white list applies to the resolved client address
+	if health("198.51.100.1") != http.StatusOK || health("198.51.100.1") != http.StatusOK {
+		t.Fatal("white listed client behind the proxy is rate limited")
+	}
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Well-known discovery routes.
+const (
+	openIDConfigurationPath   = "/.well-known/openid-configuration"
+	oauthServerMetadataPath   = "/.well-known/oauth-authorization-server"
+	jwksPath                  = "/.well-known/jwks.json"
+	tokenPath                 = "/token"
+	revocationPath            = "/revoke"
+	introspectionPath         = "/introspect"
+	explainPath               = "/auth/explain"
+	clientSecretBasicAuthType = "client_secret_basic"
+	clientSecretPostAuthType  = "client_secret_post"
+)
+
+// serverMetadata represents the authorization server metadata as defined in
+// RFC 8414 and OpenID Connect Discovery 1.0.
+type serverMetadata struct {
+	Issuer                                    string   `json:"issuer"`
+	TokenEndpoint                             string   `json:"token_endpoint"`
+	JWKSURI                                   string   `json:"jwks_uri"`
+	RevocationEndpoint                        string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint,omitempty"`
+	ResponseTypesSupported                    []string `json:"response_types_supported"`
+	GrantTypesSupported                       []string `json:"grant_types_supported"`
+	SubjectTypesSupported                     []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported          []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported,omitempty"`
+}
+
+// serverMetadata returns the metadata of the service using the issuer
+// to build the endpoint URLs. The issuer is advertised as is, since it must be
+// identical to the iss claim of the issued tokens. The response types and the
+// ID token signing algorithms are required by OpenID Connect Discovery; the
+// tokens are signed using the configured signing method.
+func (ws *Server) serverMetadata(issuer string) *serverMetadata {
+	baseURL := strings.TrimSuffix(issuer, "/")
+	metadata := &serverMetadata{
+		Issuer:                                 issuer,
+		TokenEndpoint:                          baseURL + tokenPath,
+		JWKSURI:                                baseURL + jwksPath,
+		RevocationEndpoint:                     baseURL + revocationPath,
+		ResponseTypesSupported:                 []string{"token"},
+		GrantTypesSupported:                    []string{grantTypeRefreshToken},
+		SubjectTypesSupported:                  []string{"public"},
+		IDTokenSigningAlgValuesSupported:       []string{ws.signingMethod.Alg()},
+		TokenEndpointAuthMethodsSupported:      []string{"none"},
+		RevocationEndpointAuthMethodsSupported: []string{"none"},
+	}
+	if ws.clients != nil {
+		clientAuthMethods := []string{clientSecretBasicAuthType, clientSecretPostAuthType}
+		metadata.IntrospectionEndpoint = baseURL + introspectionPath
+		metadata.GrantTypesSupported = append(metadata.GrantTypesSupported,
+			grantTypeClientCredentials)
+		metadata.TokenEndpointAuthMethodsSupported = append(clientAuthMethods, "none")
+		metadata.IntrospectionEndpointAuthMethodsSupported = clientAuthMethods
+	}
+	return metadata
+}
+
+// discoveryActionHandler serves the OpenID Connect and OAuth 2.0 authorization
+// server metadata documents of the configured issuer.
+func (ws *Server) discoveryActionHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, ws.serverMetadata(ws.issuerURL))
+}
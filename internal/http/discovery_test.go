@@ -0,0 +1,73 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/reugn/auth-server/internal/config"
+)
+
+func TestServer_Discovery(t *testing.T) {
+	const issuer = "https://auth.example.com"
+	server := newTestServer(t, func(serviceConfig *config.Service) {
+		serviceConfig.Issuer = issuer + "/"
+	})
+	expected := map[string]any{
+		"issuer":                                issuer + "/",
+		"token_endpoint":                        issuer + tokenPath,
+		"jwks_uri":                              issuer + jwksPath,
+		"revocation_endpoint":                   issuer + revocationPath,
+		"introspection_endpoint":                issuer + introspectionPath,
+		"response_types_supported":              []any{"token"},
+		"grant_types_supported":                 []any{grantTypeRefreshToken, grantTypeClientCredentials},
+		"subject_types_supported":               []any{"public"},
+		"id_token_signing_alg_values_supported": []any{"EdDSA"},
+		"token_endpoint_auth_methods_supported": []any{clientSecretBasicAuthType,
+			clientSecretPostAuthType, "none"},
+		"revocation_endpoint_auth_methods_supported":    []any{"none"},
+		"introspection_endpoint_auth_methods_supported": []any{clientSecretBasicAuthType, clientSecretPostAuthType},
+	}
+
+	for _, path := range []string{openIDConfigurationPath, oauthServerMetadataPath} {
+		t.Run(path, func(t *testing.T) {
+			// the request headers do not affect the advertised endpoints
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			request.Host = "attacker.example.com"
+			request.Header.Set("X-Forwarded-Proto", "ftp")
+			response := serve(server, request)
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status: %d", response.StatusCode)
+			}
+			var metadata map[string]any
+			if err := json.NewDecoder(response.Body).Decode(&metadata); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(metadata, expected) {
+				t.Fatalf("unexpected metadata: %v", metadata)
+			}
+		})
+	}
+
+	// the issued tokens carry the advertised issuer
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(issueTestToken(t, server), claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != expected["issuer"] {
+		t.Fatalf("unexpected iss claim: %v", claims["iss"])
+	}
+}
+
+func TestServer_DiscoveryWithoutIssuer(t *testing.T) {
+	server := newTestServer(t)
+	for _, path := range []string{openIDConfigurationPath, oauthServerMetadataPath} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		if response := serve(server, request); response.StatusCode != http.StatusNotFound {
+			t.Fatalf("unexpected status of %s: %d", path, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/reugn/auth-server/internal/auth"
+	"github.com/reugn/auth-server/internal/repository"
+)
+
+// explainResponse represents the authorization decision explanation.
+type explainResponse struct {
+	Allowed bool                   `json:"allowed"`
+	Reason  repository.Reason      `json:"reason"`
+	Role    repository.UserRole    `json:"role,omitempty"`
+	Rule    *repository.Permission `json:"rule,omitempty"`
+	Subject string                 `json:"sub,omitempty"`
+	Roles   []repository.UserRole  `json:"roles,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// newExplainResponse creates the explanation response from the decision.
+func newExplainResponse(decision *auth.Decision) *explainResponse {
+	response := &explainResponse{
+		Allowed: decision.Allowed,
+		Reason:  decision.Reason,
+		Role:    decision.Role,
+		Rule:    decision.Rule,
+	}
+	if decision.Claims != nil {
+		response.Subject = decision.Claims.Subject
+		response.Roles = decision.Claims.Roles
+	}
+	if decision.Err != nil {
+		response.Error = decision.Err.Error()
+	}
+	return response
+}
+
+// explainActionHandler explains the authorization decision of the token for
+// the request specified by the method, uri, ip and header parameters. The caller
+// must be a client authorized to access the route by its role.
+func (ws *Server) explainActionHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Authorization decision request")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if ws.clients == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	client := ws.authenticateClient(w, r)
+	if client == nil {
+		return
+	}
+	explainRequest := repository.RequestDetails{Method: r.Method, URI: explainPath}
+	if decision := ws.repository.AuthorizeRequest(client.ClientRole, explainRequest); !decision.Allowed {
+		slog.Debug("Client is not allowed to explain decisions", "client", client.ClientID)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	requestDetails, err := parseExplainRequest(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, err.Error())
+		return
+	}
+	decision := ws.jwtValidator.Authorize(r.PostFormValue("token"), requestDetails)
+	writeJSON(w, http.StatusOK, newExplainResponse(decision))
+}
+
+// parseExplainRequest parses the details of the explained request from the form
+// parameters, where each header parameter is formatted as "Name: value".
+func parseExplainRequest(r *http.Request) (*repository.RequestDetails, error) {
+	requestDetails := &repository.RequestDetails{
+		Method:   r.PostFormValue("method"),
+		URI:      r.PostFormValue("uri"),
+		ClientIP: r.PostFormValue("ip"),
+		Headers:  make(http.Header),
+	}
+	if requestDetails.Method == "" || requestDetails.URI == "" {
+		return nil, errors.New("method and uri are required")
+	}
+	if requestDetails.ClientIP != "" {
+		ip, err := netip.ParseAddr(requestDetails.ClientIP)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip: %s", requestDetails.ClientIP)
+		}
+		requestDetails.ClientIP = ip.Unmap().String()
+	}
+	for _, header := range r.PostForm["header"] {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header: %s", header)
+		}
+		requestDetails.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	_, rawQuery, _ := strings.Cut(requestDetails.URI, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri query: %w", err)
+	}
+	requestDetails.Query = query
+	return requestDetails, nil
+}
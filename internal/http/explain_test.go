@@ -0,0 +1,150 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/reugn/auth-server/internal/config"
+	"github.com/reugn/auth-server/internal/repository"
+)
+
+// explainTestRepository is the local repository configuration with a client
+// allowed and a client not allowed to explain the decisions.
+const explainTestRepository = `
+users:
+  admin:
+    # bcrypt hash of "1234"
+    password: "$2a$10$MJRrWom6waW/rpqW6tJGneDqt8X5OBFgRp2qmqqTEVOa3JJODO8pG"
+    role: admin
+clients:
+  service:
+    # bcrypt hash of "secret"
+    secret: "$2a$10$txhN3AzcMjhs75w22p/nDuE10IOpH/9/MTGzpbSdgGk5IwzJUk7mG"
+    role: admin
+  reporter:
+    secret: "$2a$10$txhN3AzcMjhs75w22p/nDuE10IOpH/9/MTGzpbSdgGk5IwzJUk7mG"
+    role: viewer
+roles:
+  viewer:
+    - method: GET
+      uri: /dashboard
+  admin:
+    inherits: [viewer]
+    permissions:
+      - method: POST
+        uri: /auth/explain
+      - method: "*"
+        uri: /admin/
+        match: prefix
+      - method: DELETE
+        uri: /admin/audit
+        effect: deny
+`
+
+func newExplainTestServer(t *testing.T) *Server {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "local.yml")
+	if err := os.WriteFile(configPath, []byte(explainTestRepository), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return newTestServer(t, func(*config.Service) {
+		t.Setenv(repository.EnvLocalConfigPath, configPath)
+	})
+}
+
+func TestServer_ExplainForbidden(t *testing.T) {
+	server := newExplainTestServer(t)
+	form := url.Values{"method": {http.MethodGet}, "uri": {"/dashboard"}}
+
+	response := postForm(server, explainPath, form, withBasicAuth("reporter", "secret"))
+	if response.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", response.StatusCode)
+	}
+	response = postForm(server, explainPath, form, withBasicAuth("service", "invalid"))
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", response.StatusCode)
+	}
+}
+
+func TestServer_ExplainInvalidRequest(t *testing.T) {
+	server := newExplainTestServer(t)
+	tests := []struct {
+		name string
+		form url.Values
+	}{
+		{"missing method", url.Values{"uri": {"/dashboard"}}},
+		{"missing uri", url.Values{"method": {http.MethodGet}}},
+		{"invalid uri query", url.Values{"method": {http.MethodGet}, "uri": {"/dashboard?a=%zz"}}},
+		{"invalid header", url.Values{"method": {http.MethodGet}, "uri": {"/dashboard"},
+			"header": {"X-Tenant"}}},
+		{"empty header name", url.Values{"method": {http.MethodGet}, "uri": {"/dashboard"},
+			"header": {": value"}}},
+		{"invalid ip", url.Values{"method": {http.MethodGet}, "uri": {"/dashboard"}, "ip": {"10.0.0"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := postForm(server, explainPath, tt.form, withBasicAuth("service", "secret"))
+			if response.StatusCode != http.StatusBadRequest {
+				t.Fatalf("unexpected status: %d", response.StatusCode)
+			}
+			if body := decodeJSON(t, response); body["error"] != errorInvalidRequest {
+				t.Fatalf("unexpected error: %v", body["error"])
+			}
+		})
+	}
+}
+
+func TestServer_Explain(t *testing.T) {
+	server := newExplainTestServer(t)
+	token := issueTestToken(t, server)
+	tests := []struct {
+		method  string
+		uri     string
+		allowed bool
+		reason  repository.Reason
+		rule    map[string]any
+	}{
+		{http.MethodGet, "/dashboard", true, repository.ReasonRuleMatched,
+			map[string]any{"method": []any{http.MethodGet}, "uri": "/dashboard", "effect": "allow"}},
+		{http.MethodPut, "/admin/users", true, repository.ReasonRuleMatched,
+			map[string]any{"method": []any{"*"}, "uri": "/admin/", "match": "prefix", "effect": "allow"}},
+		{http.MethodDelete, "/admin/audit", false, repository.ReasonExplicitDeny,
+			map[string]any{"method": []any{http.MethodDelete}, "uri": "/admin/audit", "effect": "deny"}},
+		{http.MethodGet, "/reports", false, repository.ReasonNoMatchingRule, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.uri, func(t *testing.T) {
+			response := postForm(server, explainPath, url.Values{
+				"token":  {token},
+				"method": {tt.method},
+				"uri":    {tt.uri},
+			}, withBasicAuth("service", "secret"))
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status: %d", response.StatusCode)
+			}
+			body := decodeJSON(t, response)
+			if body["allowed"] != tt.allowed || body["reason"] != string(tt.reason) {
+				t.Fatalf("unexpected decision: %v", body)
+			}
+			if body["role"] != "admin" || body["sub"] != "admin" {
+				t.Fatalf("unexpected subject: %v", body)
+			}
+			rule, _ := body["rule"].(map[string]any)
+			if tt.rule == nil {
+				if _, ok := body["rule"]; ok {
+					t.Fatalf("unexpected rule: %v", body["rule"])
+				}
+				return
+			}
+			for name, value := range tt.rule {
+				if !reflect.DeepEqual(rule[name], value) {
+					t.Fatalf("unexpected rule %s: %v", name, rule[name])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/reugn/auth-server/internal/auth"
+	"github.com/reugn/auth-server/internal/repository"
+)
+
+// OAuth 2.0 grant types.
+const (
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeRefreshToken      = "refresh_token"
+)
+
+// OAuth 2.0 token type hint of the refresh token as defined in RFC 7009, section 2.1.
+const tokenTypeHintRefreshToken = "refresh_token"
+
+// OAuth 2.0 error codes as defined in RFC 6749, section 5.2.
+const (
+	errorInvalidRequest       = "invalid_request"
+	errorInvalidClient        = "invalid_client"
+	errorInvalidGrant         = "invalid_grant"
+	errorInvalidScope         = "invalid_scope"
+	errorUnsupportedGrantType = "unsupported_grant_type"
+)
+
+// OAuth 2.0 bearer token error codes as defined in RFC 6750, section 3.1.
+const (
+	errorInvalidToken      = "invalid_token"
+	errorInsufficientScope = "insufficient_scope"
+)
+
+// errorAccountLocked is the error code of the requests to authenticate a temporarily
+// locked account.
+const errorAccountLocked = "account_locked"
+
+// authRealm is the protection space of the authentication challenges.
+const authRealm = "auth-server"
+
+// oauthError represents an OAuth 2.0 error response.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// writeOAuthError writes the OAuth 2.0 error response with the status code.
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, &oauthError{
+		Code:        code,
+		Description: description,
+	})
+}
+
+// introspectionResponse represents the token introspection response
+// as defined in RFC 7662, section 2.2.
+type introspectionResponse struct {
+	Active    bool                  `json:"active"`
+	Subject   string                `json:"sub,omitempty"`
+	Username  string                `json:"username,omitempty"`
+	ClientID  string                `json:"client_id,omitempty"`
+	Role      repository.UserRole   `json:"role,omitempty"`
+	Roles     []repository.UserRole `json:"roles,omitempty"`
+	Scope     string                `json:"scope,omitempty"`
+	TokenType string                `json:"token_type,omitempty"`
+	ExpiresAt int64                 `json:"exp,omitempty"`
+	IssuedAt  int64                 `json:"iat,omitempty"`
+	TokenID   string                `json:"jti,omitempty"`
+}
+
+// newIntrospectionResponse creates an active token introspection response
+// from the token claims.
+func newIntrospectionResponse(claims *auth.Claims) *introspectionResponse {
+	response := &introspectionResponse{
+		Active:    true,
+		Subject:   claimsSubject(claims),
+		Username:  claims.Username,
+		ClientID:  claims.ClientID,
+		Role:      claims.PrimaryRole(),
+		Roles:     claims.Roles,
+		Scope:     claims.Scope,
+		TokenType: auth.BearerToken.String(),
+		TokenID:   claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		response.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		response.IssuedAt = claims.IssuedAt.Unix()
+	}
+	return response
+}
+
+// clientCredentials parses the client credentials from the request, using either
+// the HTTP Basic authentication scheme or the request body parameters, as defined
+// in RFC 6749, section 2.3.1. The basic return value reports whether the
+// Authorization header was used.
+func clientCredentials(r *http.Request) (clientID string, clientSecret string, basic bool, ok bool) {
+	if clientID, clientSecret, basic = r.BasicAuth(); basic {
+		// the client credentials are form-urlencoded before being used in the header
+		var errID, errSecret error
+		clientID, errID = url.QueryUnescape(clientID)
+		clientSecret, errSecret = url.QueryUnescape(clientSecret)
+		return clientID, clientSecret, true, errID == nil && errSecret == nil
+	}
+	clientID = r.PostFormValue("client_id")
+	clientSecret = r.PostFormValue("client_secret")
+	return clientID, clientSecret, false, clientID != "" && clientSecret != ""
+}
+
+// writeJSON marshals the value and writes it as the response body.
+// The responses are not to be cached, since they may contain credentials.
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	marshalled, err := json.Marshal(value)
+	if err != nil {
+		slog.Error("Failed to marshal response", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s", marshalled)
+}
+
+// writeBearerChallenge writes the Bearer authentication challenge as defined in
+// RFC 6750, section 3. The error is invalid_token for the unauthorized requests,
+// and insufficient_scope for the forbidden ones. The requests without a token
+// are challenged without an error, as they lack any authentication information.
+func writeBearerChallenge(w http.ResponseWriter, status int, reason repository.Reason) {
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	if reason != auth.ReasonMissingToken {
+		code := errorInvalidToken
+		if status == http.StatusForbidden {
+			code = errorInsufficientScope
+		}
+		challenge += fmt.Sprintf(", error=%q, error_description=%q",
+			code, strings.ReplaceAll(string(reason), "_", " "))
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(status)
+}
+
+// isRefreshTokenError reports whether the refresh token exchange error is caused
+// by an invalid refresh token, rather than by a store or a repository failure.
+func isRefreshTokenError(err error) bool {
+	for _, target := range []error{auth.ErrRefreshTokenNotFound, auth.ErrRefreshTokenExpired,
+		auth.ErrRefreshTokenRevoked, auth.ErrRefreshTokenReused, auth.ErrRefreshTokenUserNotFound} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAccountLocked writes the error response of the temporarily locked account,
+// with the Retry-After header set to the remaining lockout duration in seconds.
+func writeAccountLocked(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	writeOAuthError(w, http.StatusTooManyRequests, errorAccountLocked,
+		fmt.Sprintf("account is temporarily locked, retry after %d seconds", seconds))
+}
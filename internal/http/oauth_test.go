@@ -0,0 +1,340 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/reugn/auth-server/internal/auth"
+	"github.com/reugn/auth-server/internal/config"
+	"github.com/reugn/auth-server/internal/repository"
+)
+
+// postForm serves the form-urlencoded POST request, modified by the configure
+// functions, and returns the response.
+func postForm(server *Server, path string, form url.Values,
+	configure ...func(*http.Request)) *http.Response {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, configureFunc := range configure {
+		configureFunc(request)
+	}
+	return serve(server, request)
+}
+
+// withBasicAuth returns a function setting the basic authentication of the request.
+func withBasicAuth(username, password string) func(*http.Request) {
+	return func(request *http.Request) {
+		request.SetBasicAuth(username, password)
+	}
+}
+
+// decodeJSON decodes the response body into a generic map.
+func decodeJSON(t *testing.T, response *http.Response) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// issueTestClientToken issues an access token for the local repository client
+// using the client credentials grant.
+func issueTestClientToken(t *testing.T, server *Server) string {
+	t.Helper()
+	response := postForm(server, tokenPath, url.Values{
+		"grant_type": {grantTypeClientCredentials},
+		"scope":      {"read"},
+	}, withBasicAuth("service", "secret"))
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("failed to issue client token: %d", response.StatusCode)
+	}
+	var accessToken auth.AccessToken
+	if err := json.NewDecoder(response.Body).Decode(&accessToken); err != nil {
+		t.Fatal(err)
+	}
+	return accessToken.Token
+}
+
+func TestServer_Introspect(t *testing.T) {
+	server := newTestServer(t)
+	token := issueTestClientToken(t, server)
+	introspect := func(token string) *http.Response {
+		return postForm(server, introspectionPath, url.Values{"token": {token}},
+			withBasicAuth("service", "secret"))
+	}
+
+	t.Run("active", func(t *testing.T) {
+		response := introspect(token)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status: %d", response.StatusCode)
+		}
+		body := decodeJSON(t, response)
+		claims := jwt.MapClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]any{
+			"active":     true,
+			"sub":        "service",
+			"client_id":  "service",
+			"role":       "admin",
+			"scope":      "read",
+			"token_type": auth.BearerToken.String(),
+			"exp":        claims["exp"],
+			"iat":        claims["iat"],
+			"jti":        claims["jti"],
+		}
+		for name, value := range expected {
+			if value == nil || body[name] != value {
+				t.Fatalf("unexpected %s: %v", name, body[name])
+			}
+		}
+	})
+
+	// the token signed by the server keys, expiring in a millisecond
+	t.Setenv("AUTH_SERVER_ACCESS_TOKEN_EXPIRATION_MILLIS", "1")
+	expired, err := auth.NewJWTGenerator(server.keys, jwt.SigningMethodEdDSA, auth.ClaimsConfig{}).
+		Generate(&repository.UserDetails{UserName: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	revoked := issueTestClientToken(t, server)
+	response := postForm(server, revocationPath, url.Values{"token": {revoked}})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("failed to revoke token: %d", response.StatusCode)
+	}
+	inactive := map[string]string{
+		"expired": expired.Token,
+		"revoked": revoked,
+		"garbage": "garbage",
+		"altered": token[:len(token)-4] + "AAAA",
+	}
+	for name, token := range inactive {
+		t.Run(name, func(t *testing.T) {
+			response := introspect(token)
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status: %d", response.StatusCode)
+			}
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != `{"active":false}` {
+				t.Fatalf("unexpected response: %s", body)
+			}
+		})
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		for _, configure := range []func(*http.Request){
+			func(*http.Request) {},
+			withBasicAuth("service", "wrong"),
+		} {
+			response := postForm(server, introspectionPath, url.Values{"token": {token}}, configure)
+			if response.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("unexpected status: %d", response.StatusCode)
+			}
+			if body := decodeJSON(t, response); body["error"] != errorInvalidClient {
+				t.Fatalf("unexpected error: %v", body["error"])
+			}
+		}
+	})
+
+	t.Run("method-not-allowed", func(t *testing.T) {
+		for _, method := range []string{http.MethodGet, http.MethodPut} {
+			request := httptest.NewRequest(method, introspectionPath+"?token="+token, nil)
+			request.SetBasicAuth("service", "secret")
+			if response := serve(server, request); response.StatusCode != http.StatusMethodNotAllowed {
+				t.Fatalf("unexpected status of %s: %d", method, response.StatusCode)
+			}
+		}
+	})
+}
+
+func TestServer_ClientCredentialsGrant(t *testing.T) {
+	server := newTestServer(t)
+	tests := []struct {
+		name      string
+		form      url.Values
+		configure func(*http.Request)
+		status    int
+		error     string
+		scope     string
+	}{
+		{"basic", url.Values{"grant_type": {grantTypeClientCredentials}, "scope": {"read"}},
+			withBasicAuth("service", "secret"), http.StatusOK, "", "read"},
+		{"basic-all-scopes", url.Values{"grant_type": {grantTypeClientCredentials}},
+			withBasicAuth("service", "secret"), http.StatusOK, "", "read write"},
+		{"basic-form-urlencoded", url.Values{"grant_type": {grantTypeClientCredentials}},
+			withBasicAuth("servic%65", "secre%74"), http.StatusOK, "", "read write"},
+		{"basic-invalid-encoding", url.Values{"grant_type": {grantTypeClientCredentials}},
+			withBasicAuth("service", "secret%"), http.StatusUnauthorized, errorInvalidClient, ""},
+		{"body", url.Values{"grant_type": {grantTypeClientCredentials}, "scope": {"write"},
+			"client_id": {"service"}, "client_secret": {"secret"}},
+			func(*http.Request) {}, http.StatusOK, "", "write"},
+		{"body-missing-secret", url.Values{"grant_type": {grantTypeClientCredentials},
+			"client_id": {"service"}},
+			func(*http.Request) {}, http.StatusUnauthorized, errorInvalidClient, ""},
+		{"invalid-scope", url.Values{"grant_type": {grantTypeClientCredentials}, "scope": {"read admin"}},
+			withBasicAuth("service", "secret"), http.StatusBadRequest, errorInvalidScope, ""},
+		{"unsupported-grant-type", url.Values{"grant_type": {"password"}},
+			withBasicAuth("service", "secret"), http.StatusBadRequest, errorUnsupportedGrantType, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := postForm(server, tokenPath, tt.form, tt.configure)
+			if response.StatusCode != tt.status {
+				t.Fatalf("unexpected status: %d", response.StatusCode)
+			}
+			body := decodeJSON(t, response)
+			if tt.error != "" {
+				if body["error"] != tt.error {
+					t.Fatalf("unexpected error: %v", body["error"])
+				}
+				return
+			}
+			if body["scope"] != tt.scope || body["access_token"] == nil {
+				t.Fatalf("unexpected response: %v", body)
+			}
+			// the default token lifetime of one hour in seconds
+			if body["expires_in"] != float64(3600) {
+				t.Fatalf("unexpected expires_in: %v", body["expires_in"])
+			}
+			if _, ok := body["refresh_token"]; ok {
+				t.Fatal("refresh token issued to the client")
+			}
+		})
+	}
+}
+
+// clientAuthentications returns the functions requesting a token using the valid
+// credentials of the local repository client in the supported encodings.
+func clientAuthentications(server *Server, form url.Values) []func() *http.Response {
+	bodyForm := url.Values{"client_id": {"service"}, "client_secret": {"secret"}}
+	for name, values := range form {
+		bodyForm[name] = values
+	}
+	return []func() *http.Response{
+		func() *http.Response {
+			return postForm(server, tokenPath, form, withBasicAuth("service", "secret"))
+		},
+		func() *http.Response {
+			return postForm(server, tokenPath, form, withBasicAuth("servic%65", "secret"))
+		},
+		func() *http.Response {
+			return postForm(server, tokenPath, bodyForm)
+		},
+	}
+}
+
+func TestServer_ClientLockout(t *testing.T) {
+	server := newTestServer(t, func(serviceConfig *config.Service) {
+		serviceConfig.HTTP.Lockout.Threshold = 2
+	})
+	form := url.Values{"grant_type": {grantTypeClientCredentials}}
+	for i := 0; i < 2; i++ {
+		// the failures are counted for the unescaped client id
+		response := postForm(server, tokenPath, form, withBasicAuth("servic%65", "wrong"))
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("unexpected status: %d", response.StatusCode)
+		}
+	}
+	for _, authenticate := range clientAuthentications(server, form) {
+		response := authenticate()
+		if response.StatusCode != http.StatusTooManyRequests || response.Header.Get("Retry-After") == "" {
+			t.Fatalf("unexpected status: %d", response.StatusCode)
+		}
+		if body := decodeJSON(t, response); body["error"] != errorAccountLocked {
+			t.Fatalf("unexpected error: %v", body["error"])
+		}
+	}
+	// the user accounts are locked independently of the clients
+	issueTestToken(t, server)
+	// the introspection requires the client authentication as well
+	response := postForm(server, introspectionPath, url.Values{"token": {"token"}},
+		withBasicAuth("service", "secret"))
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected introspection status: %d", response.StatusCode)
+	}
+}
+
+func TestServer_ClientRateLimit(t *testing.T) {
+	server := newTestServer(t, func(serviceConfig *config.Service) {
+		serviceConfig.HTTP.Rate.Policies = []config.RateLimitPolicy{
+			{Route: tokenPath, Key: config.RateLimitKeyUsername, Tps: 0.1, Size: 1},
+		}
+	})
+	form := url.Values{"grant_type": {grantTypeClientCredentials}}
+	response := postForm(server, tokenPath, form, withBasicAuth("service", "secret"))
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", response.StatusCode)
+	}
+	// the differently encoded client id shares the limit
+	for _, authenticate := range clientAuthentications(server, form) {
+		if response = authenticate(); response.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("unexpected status: %d", response.StatusCode)
+		}
+	}
+}
+
+func TestServer_RefreshTokenGrant(t *testing.T) {
+	server := newTestServer(t)
+	request := httptest.NewRequest(http.MethodGet, tokenPath, nil)
+	request.SetBasicAuth("admin", "1234")
+	response := serve(server, request)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("failed to issue token: %d", response.StatusCode)
+	}
+	refreshToken, _ := decodeJSON(t, response)["refresh_token"].(string)
+	if refreshToken == "" {
+		t.Fatal("refresh token is not issued")
+	}
+
+	refresh := func(refreshToken string) *http.Response {
+		return postForm(server, tokenPath, url.Values{
+			"grant_type":    {grantTypeRefreshToken},
+			"refresh_token": {refreshToken},
+		})
+	}
+	response = refresh(refreshToken)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", response.StatusCode)
+	}
+	body := decodeJSON(t, response)
+	accessToken, _ := body["access_token"].(string)
+	rotated, _ := body["refresh_token"].(string)
+	if rotated == "" || rotated == refreshToken {
+		t.Fatal("refresh token is not rotated")
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "admin" {
+		t.Fatalf("unexpected subject: %v", claims["sub"])
+	}
+
+	// the reuse of the exchanged token revokes the rotated one
+	for _, token := range []string{refreshToken, rotated, ""} {
+		response = refresh(token)
+		if response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("unexpected status: %d", response.StatusCode)
+		}
+		expected := errorInvalidGrant
+		if token == "" {
+			expected = errorInvalidRequest
+		}
+		if body = decodeJSON(t, response); body["error"] != expected {
+			t.Fatalf("unexpected error: %v", body["error"])
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/reugn/auth-server/internal/auth"
+	"github.com/reugn/auth-server/internal/config"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitPolicy limits the requests to the route by the key identifying the caller.
+type rateLimitPolicy struct {
+	route   string
+	key     string
+	header  string
+	limiter RateLimiter
+}
+
+// newRateLimitPolicies returns the default per-IP policy applied to all routes,
+// followed by the configured policies. The limiters keep the state in the store
+// shared by the service instances, or in memory if the store is nil.
+func newRateLimitPolicies(rateConfig *config.RateLimiter, store RateLimitStore) []*rateLimitPolicy {
+	newLimiter := func(_ string, tps rate.Limit, size int) RateLimiter {
+		return NewIPRateLimiter(tps, size, rateConfig.TTL, rateConfig.MaxEntries)
+	}
+	if store != nil {
+		newLimiter = func(prefix string, tps rate.Limit, size int) RateLimiter {
+			return NewSharedRateLimiter(store, prefix, tps, size)
+		}
+	}
+	policies := []*rateLimitPolicy{{
+		route:   "*",
+		key:     config.RateLimitKeyIP,
+		limiter: newLimiter("default:", rate.Limit(rateConfig.Tps), rateConfig.Size),
+	}}
+	for _, policy := range rateConfig.Policies {
+		// the store keys identify the policy regardless of its position in the
+		// configuration, the route paths contain no spaces
+		prefix := fmt.Sprintf("%s %s %s:", policy.Route, policy.Key, policy.Header)
+		policies = append(policies, &rateLimitPolicy{
+			route:   policy.Route,
+			key:     policy.Key,
+			header:  policy.Header,
+			limiter: newLimiter(prefix, rate.Limit(policy.Tps), policy.Size),
+		})
+	}
+	// the subject key requires the token signature verification, so it is
+	// evaluated after the other policies have allowed the request
+	slices.SortStableFunc(policies, func(a, b *rateLimitPolicy) int {
+		return cmpBool(a.key == config.RateLimitKeySubject, b.key == config.RateLimitKeySubject)
+	})
+	return policies
+}
+
+// newRateLimitStore returns the configured shared rate limiter store,
+// or nil for the local one.
+func newRateLimitStore(storeConfig *config.RateLimitStore) (RateLimitStore, error) {
+	if storeConfig.Type != config.RateLimitStoreAerospike {
+		return nil, nil
+	}
+	return NewAerospikeRateLimitStore(storeConfig.Host, storeConfig.Port,
+		storeConfig.Namespace, storeConfig.Set)
+}
+
+// cmpBool compares the booleans, false being less than true.
+func cmpBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// matches reports whether the policy applies to the request path.
+func (p *rateLimitPolicy) matches(path string) bool {
+	return p.route == "*" || p.route == path
+}
+
+// rateLimitExceeded describes the exceeded rate limit.
+type rateLimitExceeded struct {
+	limit      rate.Limit
+	burst      int
+	retryAfter time.Duration
+}
+
+// limitRequest applies the matching rate limit policies to the request from
+// the client IP address. The request consumes a token from each of the policies
+// only if all of them allow it. It returns the exceeded limit with the longest
+// delay, or nil if the request is allowed. The policies failing to access
+// the rate limiter store do not limit the request. The subject policies are
+// skipped once the request is rejected by the others.
+func (ws *Server) limitRequest(r *http.Request, ip string) *rateLimitExceeded {
+	now := time.Now()
+	whiteListed := ws.ipWhiteList.isAllowed(ip)
+	reservations := make([]*RateLimitReservation, 0, len(ws.rateLimitPolicies))
+	var exceeded *rateLimitExceeded
+	for _, policy := range ws.rateLimitPolicies {
+		if !policy.matches(r.URL.Path) || (policy.key == config.RateLimitKeyIP && whiteListed) {
+			continue
+		}
+		if policy.key == config.RateLimitKeySubject && exceeded != nil {
+			break
+		}
+		key, ok := ws.rateLimitKey(policy, r, ip)
+		if !ok {
+			continue
+		}
+		reservation, err := policy.limiter.Reserve(key, now)
+		if err != nil {
+			slog.Warn("Failed to apply rate limit", "route", policy.route,
+				"key", policy.key, "err", err)
+			continue
+		}
+		if !reservation.OK() {
+			if exceeded == nil || reservation.Delay > exceeded.retryAfter {
+				exceeded = &rateLimitExceeded{
+					limit:      policy.limiter.Limit(),
+					burst:      policy.limiter.Burst(),
+					retryAfter: reservation.Delay,
+				}
+			}
+			continue
+		}
+		reservations = append(reservations, reservation)
+	}
+	if exceeded != nil {
+		for _, reservation := range reservations {
+			reservation.Cancel()
+		}
+	}
+	return exceeded
+}
+
+// rateLimitKey returns the key of the request for the policy, reporting
+// whether the request contains the key.
+func (ws *Server) rateLimitKey(policy *rateLimitPolicy, r *http.Request, ip string) (string, bool) {
+	var key string
+	switch policy.key {
+	case config.RateLimitKeyIP:
+		key = ip
+	case config.RateLimitKeyUsername:
+		if r.Method == http.MethodPost {
+			// the client credentials, unescaped as on authentication
+			key, _, _, _ = clientCredentials(r)
+		} else {
+			key, _, _ = r.BasicAuth()
+		}
+	case config.RateLimitKeySubject:
+		if token := ws.parser.ParseAuthorizationToken(r); token != "" {
+			if claims, err := ws.jwtValidator.Validate(token); err == nil {
+				key = claimsSubject(claims)
+			}
+		}
+	case config.RateLimitKeyHeader:
+		key = r.Header.Get(policy.header)
+	}
+	return key, key != ""
+}
+
+// claimsSubject returns the subject of the token, falling back to the username
+// and the client id for the tokens without the sub claim.
+func claimsSubject(claims *auth.Claims) string {
+	switch {
+	case claims.Subject != "":
+		return claims.Subject
+	case claims.Username != "":
+		return claims.Username
+	default:
+		return claims.ClientID
+	}
+}
+
+// writeRateLimitExceeded writes the 429 response with the Retry-After header and
+// the RateLimit-* headers as defined in draft-ietf-httpapi-ratelimit-headers.
+func writeRateLimitExceeded(w http.ResponseWriter, exceeded *rateLimitExceeded) {
+	retryAfter := strconv.FormatInt(int64(math.Ceil(exceeded.retryAfter.Seconds())), 10)
+	window := int64(math.Ceil(float64(exceeded.burst) / float64(exceeded.limit)))
+	w.Header().Set("Retry-After", retryAfter)
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(exceeded.burst))
+	w.Header().Set("RateLimit-Remaining", "0")
+	w.Header().Set("RateLimit-Reset", retryAfter)
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", exceeded.burst, window))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
@@ -1,10 +1,13 @@
 package http
 
 import (
+	"container/list"
 	"log/slog"
 	"net/netip"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/time/rate"
 )
@@ -70,52 +73,221 @@ func (wl *IPWhiteList) isAllowed(ip string) bool {
 	return false
 }
 
+// RateLimiter limits the rate of the requests per key using a token bucket of
+// Burst tokens refilled at Limit tokens per second.
+type RateLimiter interface {
+	// Reserve takes a token from the bucket of the key at the time if available.
+	// Otherwise, it takes nothing and returns the delay until a token is available.
+	Reserve(key string, now time.Time) (*RateLimitReservation, error)
+	// Limit returns the bucket refill rate.
+	Limit() rate.Limit
+	// Burst returns the bucket size.
+	Burst() int
+}
+
+// RateLimitReservation is the result of a rate limiter token reservation.
+type RateLimitReservation struct {
+	// Delay is the duration until a token is available, zero if the token is taken.
+	Delay  time.Duration
+	cancel func()
+}
+
+// OK reports whether the token is taken.
+func (r *RateLimitReservation) OK() bool {
+	return r.Delay <= 0
+}
+
+// Cancel returns the taken token to the bucket.
+func (r *RateLimitReservation) Cancel() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
 // IPAddress represents an IP address string.
 type IPAddress string
 
+const (
+	// rateLimiterShards is the number of the IPRateLimiter shards, a power of two.
+	rateLimiterShards = 32
+	// rateLimiterClockResolution is the update interval of the IPRateLimiter clock.
+	rateLimiterClockResolution = time.Second
+)
+
 // IPRateLimiter represents a rate limiter based on an IP address.
+// The limiters are distributed across the shards by the address hash to reduce
+// lock contention. The limiters idle for longer than the ttl are evicted by
+// a background janitor, and the least recently used ones are evicted when
+// a shard exceeds its share of the maximum number of entries.
 type IPRateLimiter struct {
-	sync.Mutex
-	limiters        map[IPAddress]*rate.Limiter
+	shards          [rateLimiterShards]*limiterShard
 	tokensPerSecond rate.Limit
 	tokenBucketSize int
+	ttl             time.Duration
+	// clock is the coarse current time in Unix nanoseconds updated by the janitor,
+	// sparing the time.Now call on every access.
+	clock    atomic.Int64
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
-// NewIPRateLimiter returns a new IPRateLimiter.
-func NewIPRateLimiter(tps rate.Limit, size int) *IPRateLimiter {
+var _ RateLimiter = (*IPRateLimiter)(nil)
+
+// limiterShard holds a subset of the rate limiters ordered by the last access,
+// the most recently used in the front.
+type limiterShard struct {
+	sync.Mutex
+	limiters   map[IPAddress]*list.Element
+	lru        *list.List
+	maxEntries int
+}
+
+// limiterEntry is the limiterShard list element value.
+type limiterEntry struct {
+	ipAddr   IPAddress
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewIPRateLimiter returns a new IPRateLimiter, holding approximately up to
+// maxEntries limiters evicted after being idle for the ttl. It starts the
+// janitor goroutine, stopped by Close.
+func NewIPRateLimiter(tps rate.Limit, size int, ttl time.Duration, maxEntries int) *IPRateLimiter {
 	ipLimiter := &IPRateLimiter{
-		limiters:        make(map[IPAddress]*rate.Limiter),
 		tokensPerSecond: tps,
 		tokenBucketSize: size,
+		ttl:             ttl,
+		stop:            make(chan struct{}),
+	}
+	ipLimiter.clock.Store(time.Now().UnixNano())
+	shardEntries := max((maxEntries+rateLimiterShards-1)/rateLimiterShards, 1)
+	for i := range ipLimiter.shards {
+		ipLimiter.shards[i] = &limiterShard{
+			limiters:   make(map[IPAddress]*list.Element),
+			lru:        list.New(),
+			maxEntries: shardEntries,
+		}
 	}
+	go ipLimiter.janitor()
 
 	return ipLimiter
 }
 
-// AddLimiter creates a new rate limiter and adds it to the limiters map,
-// using the IP address as the key.
-func (ipLimiter *IPRateLimiter) AddLimiter(ipAddr string) *rate.Limiter {
-	ipLimiter.Lock()
-	defer ipLimiter.Unlock()
+// GetLimiter returns the rate limiter for the provided IP address,
+// creating a new one if it does not exist.
+func (ipLimiter *IPRateLimiter) GetLimiter(ipAddr string) *rate.Limiter {
+	return ipLimiter.getLimiter(IPAddress(ipAddr), time.Unix(0, ipLimiter.clock.Load()))
+}
 
-	limiter := rate.NewLimiter(ipLimiter.tokensPerSecond, ipLimiter.tokenBucketSize)
-	ipLimiter.limiters[IPAddress(ipAddr)] = limiter
+// Reserve implements the RateLimiter interface.
+func (ipLimiter *IPRateLimiter) Reserve(key string, now time.Time) (*RateLimitReservation, error) {
+	reservation := ipLimiter.GetLimiter(key).ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return &RateLimitReservation{Delay: delay}, nil
+	}
+	return &RateLimitReservation{
+		cancel: func() { reservation.CancelAt(now) },
+	}, nil
+}
 
-	return limiter
+// Limit implements the RateLimiter interface.
+func (ipLimiter *IPRateLimiter) Limit() rate.Limit {
+	return ipLimiter.tokensPerSecond
 }
 
-// GetLimiter returns the rate limiter for the provided IP address if it exists.
-// Otherwise calls AddLimiter to add a new limiter to the map.
-func (ipLimiter *IPRateLimiter) GetLimiter(ipAddr string) *rate.Limiter {
-	ipLimiter.Lock()
-	limiter, exists := ipLimiter.limiters[IPAddress(ipAddr)]
+// Burst implements the RateLimiter interface.
+func (ipLimiter *IPRateLimiter) Burst() int {
+	return ipLimiter.tokenBucketSize
+}
+
+func (ipLimiter *IPRateLimiter) getLimiter(ipAddr IPAddress, now time.Time) *rate.Limiter {
+	shard := ipLimiter.shard(ipAddr)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if element, ok := shard.limiters[ipAddr]; ok {
+		entry := element.Value.(*limiterEntry)
+		entry.lastSeen = now
+		shard.lru.MoveToFront(element)
+		return entry.limiter
+	}
+
+	entry := &limiterEntry{
+		ipAddr:   ipAddr,
+		limiter:  rate.NewLimiter(ipLimiter.tokensPerSecond, ipLimiter.tokenBucketSize),
+		lastSeen: now,
+	}
+	shard.limiters[ipAddr] = shard.lru.PushFront(entry)
+	for shard.lru.Len() > shard.maxEntries {
+		shard.remove(shard.lru.Back())
+	}
+	return entry.limiter
+}
+
+// Len returns the number of the rate limiters.
+func (ipLimiter *IPRateLimiter) Len() int {
+	var n int
+	for _, shard := range ipLimiter.shards {
+		shard.Lock()
+		n += shard.lru.Len()
+		shard.Unlock()
+	}
+	return n
+}
 
-	if !exists {
-		ipLimiter.Unlock()
-		return ipLimiter.AddLimiter(ipAddr)
+// Close stops the janitor goroutine.
+func (ipLimiter *IPRateLimiter) Close() {
+	ipLimiter.stopOnce.Do(func() { close(ipLimiter.stop) })
+}
+
+// shard returns the shard of the IP address using the FNV-1a hash.
+func (ipLimiter *IPRateLimiter) shard(ipAddr IPAddress) *limiterShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(ipAddr); i++ {
+		hash ^= uint32(ipAddr[i])
+		hash *= 16777619
+	}
+	return ipLimiter.shards[hash&(rateLimiterShards-1)]
+}
+
+// janitor updates the clock and periodically evicts the idle rate limiters
+// until closed.
+func (ipLimiter *IPRateLimiter) janitor() {
+	ticker := time.NewTicker(rateLimiterClockResolution)
+	defer ticker.Stop()
+	lastEvicted := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			ipLimiter.clock.Store(now.UnixNano())
+			if now.Sub(lastEvicted) >= ipLimiter.ttl/2 {
+				ipLimiter.evictIdle(now)
+				lastEvicted = now
+			}
+		case <-ipLimiter.stop:
+			return
+		}
 	}
+}
 
-	ipLimiter.Unlock()
+// evictIdle removes the rate limiters not accessed since now minus the ttl.
+func (ipLimiter *IPRateLimiter) evictIdle(now time.Time) {
+	deadline := now.Add(-ipLimiter.ttl)
+	for _, shard := range ipLimiter.shards {
+		shard.Lock()
+		for element := shard.lru.Back(); element != nil; element = shard.lru.Back() {
+			if element.Value.(*limiterEntry).lastSeen.After(deadline) {
+				break
+			}
+			shard.remove(element)
+		}
+		shard.Unlock()
+	}
+}
 
-	return limiter
+// remove removes the list element from the shard.
+func (shard *limiterShard) remove(element *list.Element) {
+	shard.lru.Remove(element)
+	delete(shard.limiters, element.Value.(*limiterEntry).ipAddr)
 }
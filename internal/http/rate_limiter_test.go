@@ -0,0 +1,141 @@
+package http
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestIPRateLimiter_GetLimiter(t *testing.T) {
+	ipLimiter := NewIPRateLimiter(1, 1, time.Minute, 1024)
+	defer ipLimiter.Close()
+
+	limiter := ipLimiter.GetLimiter("10.0.0.1")
+	if ipLimiter.GetLimiter("10.0.0.1") != limiter {
+		t.Fatal("limiter was not reused")
+	}
+	if ipLimiter.GetLimiter("10.0.0.2") == limiter {
+		t.Fatal("limiter was shared between addresses")
+	}
+	if !limiter.Allow() || limiter.Allow() {
+		t.Fatal("unexpected limiter state")
+	}
+	if ipLimiter.Len() != 2 {
+		t.Fatalf("unexpected number of limiters: %d", ipLimiter.Len())
+	}
+}
+
+func TestIPRateLimiter_EvictIdle(t *testing.T) {
+	ipLimiter := NewIPRateLimiter(1, 1, time.Minute, 1024)
+	defer ipLimiter.Close()
+
+	now := time.Now()
+	idle := ipLimiter.getLimiter("10.0.0.1", now.Add(-2*time.Minute))
+	active := ipLimiter.getLimiter("10.0.0.2", now.Add(-2*time.Minute))
+	// accessing the limiter refreshes its last seen time
+	ipLimiter.getLimiter("10.0.0.2", now)
+	ipLimiter.getLimiter("10.0.0.3", now)
+
+	ipLimiter.evictIdle(now)
+	if ipLimiter.Len() != 2 {
+		t.Fatalf("unexpected number of limiters: %d", ipLimiter.Len())
+	}
+	if ipLimiter.getLimiter("10.0.0.1", now) == idle {
+		t.Fatal("idle limiter was not evicted")
+	}
+	if ipLimiter.getLimiter("10.0.0.2", now) != active {
+		t.Fatal("active limiter was evicted")
+	}
+}
+
+func TestIPRateLimiter_MaxEntries(t *testing.T) {
+	maxEntries := 4 * rateLimiterShards
+	ipLimiter := NewIPRateLimiter(1, 1, time.Minute, maxEntries)
+	defer ipLimiter.Close()
+
+	first := ipLimiter.GetLimiter("10.0.0.0")
+	for i := 1; i < 10*maxEntries; i++ {
+		ipLimiter.GetLimiter(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		if ipLimiter.Len() > maxEntries {
+			t.Fatalf("number of limiters exceeded the maximum: %d", ipLimiter.Len())
+		}
+	}
+	if ipLimiter.GetLimiter("10.0.0.0") == first {
+		t.Fatal("least recently used limiter was not evicted")
+	}
+}
+
+func TestIPRateLimiter_Concurrent(t *testing.T) {
+	ipLimiter := NewIPRateLimiter(rate.Inf, 1, time.Minute, 1024)
+	defer ipLimiter.Close()
+
+	var wg sync.WaitGroup
+	limiters := make([]*rate.Limiter, 16)
+	for i := range limiters {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limiters[i] = ipLimiter.GetLimiter("10.0.0.1")
+		}(i)
+	}
+	wg.Wait()
+	for _, limiter := range limiters {
+		if limiter != limiters[0] {
+			t.Fatal("concurrent calls created different limiters")
+		}
+	}
+}
+
+// mutexRateLimiter is the unbounded single mutex rate limiter, a baseline
+// for the IPRateLimiter benchmarks.
+type mutexRateLimiter struct {
+	sync.Mutex
+	limiters map[IPAddress]*rate.Limiter
+}
+
+func (l *mutexRateLimiter) GetLimiter(ipAddr string) *rate.Limiter {
+	l.Lock()
+	defer l.Unlock()
+	limiter, ok := l.limiters[IPAddress(ipAddr)]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Inf, 1)
+		l.limiters[IPAddress(ipAddr)] = limiter
+	}
+	return limiter
+}
+
+// benchmarkGetLimiter benchmarks the concurrent limiter lookups of the number
+// of addresses, a single address being the most contended case.
+func benchmarkGetLimiter(b *testing.B, getLimiter func(string) *rate.Limiter, numAddresses int) {
+	addresses := make([]string, numAddresses)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	}
+	var counter atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := counter.Add(1) * 7919
+		for pb.Next() {
+			getLimiter(addresses[i%uint64(len(addresses))]).Allow()
+			i++
+		}
+	})
+}
+
+func BenchmarkIPRateLimiter_GetLimiter(b *testing.B) {
+	for _, numAddresses := range []int{1, 4096} {
+		b.Run(fmt.Sprintf("sharded-%d", numAddresses), func(b *testing.B) {
+			ipLimiter := NewIPRateLimiter(rate.Inf, 1, time.Minute, 100000)
+			defer ipLimiter.Close()
+			benchmarkGetLimiter(b, ipLimiter.GetLimiter, numAddresses)
+		})
+		b.Run(fmt.Sprintf("mutex-%d", numAddresses), func(b *testing.B) {
+			mutexLimiter := &mutexRateLimiter{limiters: make(map[IPAddress]*rate.Limiter)}
+			benchmarkGetLimiter(b, mutexLimiter.GetLimiter, numAddresses)
+		})
+	}
+}
@@ -1,43 +1,66 @@
 package http
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"net"
 	"net/http"
+	"slices"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/reugn/auth-server/internal/auth"
 	"github.com/reugn/auth-server/internal/config"
 	"github.com/reugn/auth-server/internal/proxy"
 	"github.com/reugn/auth-server/internal/repository"
-	"golang.org/x/time/rate"
 )
 
 // Server represents the entry point to interact with the service via HTTP requests.
 type Server struct {
-	address      string
-	version      string
-	parser       proxy.RequestParser
-	repository   repository.Repository
-	rateLimiter  *IPRateLimiter
-	ipWhiteList  *IPWhiteList
-	jwtGenerator *auth.JWTGenerator
-	jwtValidator *auth.JWTValidator
+	httpServer    *http.Server
+	version       string
+	issuerURL     string
+	signingMethod jwt.SigningMethod
+	parser        proxy.RequestParser
+	keys          *auth.Keys
+	repository    repository.Repository
+	clients       repository.ClientRegistry
+	ipWhiteList   *IPWhiteList
+	ipResolver    *ClientIPResolver
+	jwtGenerator  *auth.JWTGenerator
+	jwtValidator  *auth.JWTValidator
+	refreshTokens *auth.RefreshTokenManager
+	lockout       *auth.Lockout
+	// authHeaders maps the /auth response header names to the token claims.
+	authHeaders map[string]string
+	// rateLimitPolicies contains the default per-IP policy and the configured ones.
+	rateLimitPolicies []*rateLimitPolicy
+	// rateLimitStore is the shared rate limiter state store, nil if local.
+	rateLimitStore RateLimitStore
 }
 
 // NewServer returns a new instance of Server.
 func NewServer(version string, keys *auth.Keys, config *config.Service) (*Server, error) {
 	address := fmt.Sprintf("%s:%d", config.HTTP.Host, config.HTTP.Port)
-	repository, err := config.Repository()
+	repo, err := config.Repository()
 	if err != nil {
 		return nil, err
 	}
-	signingMethod, err := config.SigningMethodRSA()
+	signingMethod, err := config.JWTSigningMethod()
 	if err != nil {
 		return nil, err
 	}
-	generator := auth.NewJWTGenerator(keys, signingMethod)
-	validator := auth.NewJWTValidator(keys, repository)
+	if err = keys.Validate(signingMethod); err != nil {
+		return nil, err
+	}
+	claimsConfig := auth.ClaimsConfig{
+		Issuer:   config.Issuer,
+		Audience: config.Audience,
+		Leeway:   config.ClockSkew,
+	}
+	generator := auth.NewJWTGenerator(keys, signingMethod, claimsConfig)
+	validator := auth.NewJWTValidator(keys, signingMethod, claimsConfig, repo,
+		auth.NewMemoryRevocationStore())
 
 	requestParser, err := config.RequestParser()
 	if err != nil {
@@ -47,40 +70,105 @@ func NewServer(version string, keys *auth.Keys, config *config.Service) (*Server
 	if err != nil {
 		return nil, err
 	}
-	return &Server{
-		address:      address,
-		version:      version,
-		parser:       requestParser,
-		repository:   repository,
-		rateLimiter:  NewIPRateLimiter(rate.Limit(config.HTTP.Rate.Tps), config.HTTP.Rate.Size),
-		ipWhiteList:  ipWhiteList,
-		jwtGenerator: generator,
-		jwtValidator: validator,
-	}, nil
+	ipResolver, err := NewClientIPResolver(config.HTTP.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	lockoutConfig := auth.LockoutConfig{
+		Threshold:   config.HTTP.Lockout.Threshold,
+		Duration:    config.HTTP.Lockout.Duration,
+		MaxDuration: config.HTTP.Lockout.MaxDuration,
+		ResetAfter:  config.HTTP.Lockout.ResetAfter,
+	}
+	rateLimitStore, err := newRateLimitStore(&config.HTTP.Rate.Store)
+	if err != nil {
+		return nil, err
+	}
+	refreshTokenStore := auth.NewMemoryRefreshTokenStore(config.HTTP.RefreshToken.MaxEntries)
+	// the client credentials grant is enabled if supported by the repository
+	clients, _ := repo.(repository.ClientRegistry)
+	server := &Server{
+		httpServer:    &http.Server{Addr: address},
+		version:       version,
+		issuerURL:     config.Issuer,
+		signingMethod: signingMethod,
+		parser:        requestParser,
+		keys:          keys,
+		repository:    repo,
+		clients:       clients,
+		ipWhiteList:   ipWhiteList,
+		ipResolver:    ipResolver,
+		jwtGenerator:  generator,
+		jwtValidator:  validator,
+		refreshTokens: auth.NewRefreshTokenManager(refreshTokenStore, repo),
+		lockout:       auth.NewLockout(lockoutConfig, auth.NewMemoryLockoutStore(config.HTTP.Lockout.MaxEntries)),
+		authHeaders:   config.HTTP.AuthResponseHeaders,
+
+		rateLimitPolicies: newRateLimitPolicies(&config.HTTP.Rate, rateLimitStore),
+		rateLimitStore:    rateLimitStore,
+	}
+	server.httpServer.Handler = server.handler()
+	return server, nil
 }
 
-func (ws *Server) rateLimiterMiddleware(next http.Handler) http.Handler {
+// clientIPMiddleware resolves the client IP address of the request and sets it
+// in the request context.
+func (ws *Server) clientIPMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip, err := ws.ipResolver.ClientIP(r)
 		if err != nil {
+			slog.Error("Failed to resolve client ip", "remote", r.RemoteAddr, "err", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError),
 				http.StatusInternalServerError)
 			return
 		}
-		if !ws.ipWhiteList.isAllowed(ip) {
-			limiter := ws.rateLimiter.GetLimiter(ip)
-			if !limiter.Allow() {
-				http.Error(w, http.StatusText(http.StatusTooManyRequests),
-					http.StatusTooManyRequests)
-				return
-			}
+		next.ServeHTTP(w, withClientIP(r, ip))
+	})
+}
+
+func (ws *Server) rateLimiterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if exceeded := ws.limitRequest(r, ip); exceeded != nil {
+			slog.Debug("Rate limit exceeded", "ip", ip, "path", r.URL.Path)
+			writeRateLimitExceeded(w, exceeded)
+			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// Start initiates the HTTP server.
+// Start initiates the HTTP server. It returns nil once the server is shut down.
 func (ws *Server) Start() error {
+	if err := ws.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gracefully shuts down the HTTP server waiting for the active
+// requests to complete, and closes the server.
+func (ws *Server) Shutdown(ctx context.Context) error {
+	err := ws.httpServer.Shutdown(ctx)
+	ws.Close()
+	return err
+}
+
+// Close stops the background goroutines of the local rate limiters and closes
+// the shared rate limit store.
+func (ws *Server) Close() {
+	for _, policy := range ws.rateLimitPolicies {
+		if limiter, ok := policy.limiter.(interface{ Close() }); ok {
+			limiter.Close()
+		}
+	}
+	if store, ok := ws.rateLimitStore.(interface{ Close() }); ok {
+		store.Close()
+	}
+}
+
+// handler returns the HTTP handler serving the service routes.
+func (ws *Server) handler() http.Handler {
 	mux := http.NewServeMux()
 
 	// root route
@@ -95,13 +183,33 @@ func (ws *Server) Start() error {
 	// version route
 	mux.HandleFunc("/version", ws.versionActionHandler)
 
-	// token issuing route, requires basic authentication
-	mux.HandleFunc("/token", ws.tokenActionHandler)
+	// token issuing route, requires basic authentication, client credentials
+	// or a refresh token
+	mux.HandleFunc(tokenPath, ws.tokenActionHandler)
 
 	// authorization route, requires a JSON Web Token
 	mux.HandleFunc("/auth", ws.authActionHandler)
 
-	return http.ListenAndServe(ws.address, ws.rateLimiterMiddleware(mux))
+	// authorization decision route, requires client authentication
+	mux.HandleFunc(explainPath, ws.explainActionHandler)
+
+	// token revocation route
+	mux.HandleFunc(revocationPath, ws.revokeActionHandler)
+
+	// token introspection route, requires client authentication
+	mux.HandleFunc(introspectionPath, ws.introspectActionHandler)
+
+	// public keys route, serves the JSON Web Key Set
+	mux.HandleFunc(jwksPath, ws.jwksActionHandler)
+
+	// discovery routes, serve the authorization server metadata if the issuer
+	// is configured, since it cannot be trusted when derived from the request
+	if ws.issuerURL != "" {
+		mux.HandleFunc(openIDConfigurationPath, ws.discoveryActionHandler)
+		mux.HandleFunc(oauthServerMetadataPath, ws.discoveryActionHandler)
+	}
+
+	return ws.clientIPMiddleware(ws.rateLimiterMiddleware(mux))
 }
 
 func rootActionHandler(w http.ResponseWriter, r *http.Request) {
@@ -125,35 +233,254 @@ func (ws *Server) versionActionHandler(w http.ResponseWriter, _ *http.Request) {
 
 func (ws *Server) tokenActionHandler(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("Token generation request")
+	if r.Method == http.MethodPost && r.PostFormValue("grant_type") != "" {
+		ws.tokenGrantHandler(w, r)
+		return
+	}
 	user, pass, ok := r.BasicAuth()
 	if !ok {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if retryAfter := ws.lockout.Attempt(user); retryAfter > 0 {
+		slog.Debug("Account is locked", "user", user)
+		writeAccountLocked(w, retryAfter)
+		return
+	}
 	userDetails := ws.repository.AuthenticateBasic(user, pass)
 	if userDetails == nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	accessToken, err := ws.jwtGenerator.Generate(userDetails.UserName, userDetails.UserRole)
+	ws.lockout.RecordSuccess(user)
+	refreshToken, err := ws.refreshTokens.Issue(userDetails)
 	if err != nil {
+		slog.Error("Failed to issue refresh token", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	ws.writeAccessToken(w, userDetails, refreshToken)
+}
+
+// tokenGrantHandler handles the OAuth 2.0 token requests.
+func (ws *Server) tokenGrantHandler(w http.ResponseWriter, r *http.Request) {
+	switch grantType := r.PostFormValue("grant_type"); grantType {
+	case grantTypeClientCredentials:
+		ws.clientCredentialsGrantHandler(w, r)
+	case grantTypeRefreshToken:
+		ws.refreshTokenGrantHandler(w, r)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, errorUnsupportedGrantType,
+			fmt.Sprintf("unsupported grant type: %s", grantType))
+	}
+}
+
+// clientCredentialsGrantHandler issues an access token to the authenticated client
+// as defined in RFC 6749, section 4.4.
+func (ws *Server) clientCredentialsGrantHandler(w http.ResponseWriter, r *http.Request) {
+	if ws.clients == nil {
+		writeOAuthError(w, http.StatusBadRequest, errorUnsupportedGrantType,
+			"client credentials are not supported by the repository")
+		return
+	}
+	client := ws.authenticateClient(w, r)
+	if client == nil {
+		return
+	}
+	scopes, err := client.GrantScopes(repository.ParseScopes(r.PostFormValue("scope")))
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidScope, err.Error())
+		return
+	}
+	accessToken, err := ws.jwtGenerator.GenerateForClient(client, scopes)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeTokenResponse(w, accessToken)
+}
+
+// authenticateClient authenticates the OAuth 2.0 client. It writes the
+// invalid_client error response and returns nil if the authentication fails.
+// The failed attempts lock the client as the basic authentication ones lock
+// the user accounts.
+func (ws *Server) authenticateClient(w http.ResponseWriter, r *http.Request) *repository.ClientDetails {
+	clientID, clientSecret, basic, ok := clientCredentials(r)
+	if ok {
+		lockoutKey := clientLockoutKey(clientID)
+		if retryAfter := ws.lockout.Attempt(lockoutKey); retryAfter > 0 {
+			slog.Debug("Client is locked", "client", clientID)
+			writeAccountLocked(w, retryAfter)
+			return nil
+		}
+		if client := ws.clients.AuthenticateClient(clientID, clientSecret); client != nil {
+			ws.lockout.RecordSuccess(lockoutKey)
+			return client
+		}
+	}
+	slog.Debug("Failed to authenticate client", "client", clientID)
+	if basic {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+	}
+	writeOAuthError(w, http.StatusUnauthorized, errorInvalidClient,
+		"client authentication failed")
+	return nil
+}
+
+// clientLockoutKey returns the lockout key of the client, distinct from
+// the usernames.
+func clientLockoutKey(clientID string) string {
+	return "client:" + clientID
+}
+
+// refreshTokenGrantHandler exchanges a refresh token for a new access token,
+// rotating the refresh token.
+func (ws *Server) refreshTokenGrantHandler(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.PostFormValue("refresh_token")
+	if refreshToken == "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest,
+			"refresh_token is required")
+		return
+	}
+	userDetails, rotated, err := ws.refreshTokens.Exchange(refreshToken)
+	if err != nil {
+		if !isRefreshTokenError(err) {
+			slog.Error("Failed to exchange refresh token", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		slog.Debug("Failed to exchange refresh token", "err", err)
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidGrant, err.Error())
+		return
+	}
+	ws.writeAccessToken(w, userDetails, rotated)
+}
+
+// writeAccessToken generates an access token for the user and writes
+// the token response.
+func (ws *Server) writeAccessToken(w http.ResponseWriter, userDetails *repository.UserDetails,
+	refreshToken string) {
+	accessToken, err := ws.jwtGenerator.Generate(userDetails)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	accessToken.RefreshToken = refreshToken
+	writeTokenResponse(w, accessToken)
+}
+
+// writeTokenResponse writes the access token response.
+func writeTokenResponse(w http.ResponseWriter, accessToken *auth.AccessToken) {
 	marshalled, err := accessToken.Marshal()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	fmt.Fprintf(w, "%s", marshalled)
 }
 
 func (ws *Server) authActionHandler(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("Token authorization request")
 	requestDetails := ws.parser.ParseRequestDetails(r)
+	requestDetails.ClientIP = clientIP(r)
 	authToken := ws.parser.ParseAuthorizationToken(r)
 
-	if !ws.jwtValidator.Authorize(authToken, requestDetails) {
-		w.WriteHeader(http.StatusUnauthorized)
+	decision := ws.jwtValidator.Authorize(authToken, requestDetails)
+	switch {
+	case !decision.TokenValid():
+		// the client is to obtain a new token
+		writeBearerChallenge(w, http.StatusUnauthorized, decision.Reason)
+	case decision.Reason == repository.ReasonRepositoryError:
+		// the permissions are unavailable, the token is not at fault
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case decision.Reason == repository.ReasonConditionError:
+		w.WriteHeader(http.StatusInternalServerError)
+	case !decision.Allowed:
+		// the token is valid, but lacks the permissions
+		writeBearerChallenge(w, http.StatusForbidden, decision.Reason)
+	default:
+		ws.writeAuthHeaders(w, decision.Claims)
+	}
+}
+
+// writeAuthHeaders sets the configured identity headers from the token claims.
+// The headers of the claims which are not set are sent empty, so that the proxy
+// overrides any client-supplied copies of them.
+func (ws *Server) writeAuthHeaders(w http.ResponseWriter, claims *auth.Claims) {
+	for header, claim := range ws.authHeaders {
+		value, _ := claims.Value(claim)
+		w.Header().Set(header, value)
+	}
+}
+
+// revokeActionHandler revokes an access or a refresh token as defined in RFC 7009.
+func (ws *Server) revokeActionHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Token revocation request")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "token is required")
+		return
+	}
+
+	revokers := []func(string) error{ws.jwtValidator.Revoke, ws.refreshTokens.Revoke}
+	if r.PostFormValue("token_type_hint") == tokenTypeHintRefreshToken {
+		slices.Reverse(revokers)
+	}
+	for _, revoke := range revokers {
+		err := revoke(token)
+		if err == nil {
+			break
+		}
+		slog.Debug("Failed to revoke token", "err", err)
 	}
+
+	// invalid tokens do not cause an error response, as per RFC 7009
+	w.WriteHeader(http.StatusOK)
+}
+
+// introspectActionHandler returns the state of an access token as defined in RFC 7662.
+func (ws *Server) introspectActionHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Token introspection request")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if ws.clients == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	// authenticate the caller to prevent token scanning
+	if client := ws.authenticateClient(w, r); client == nil {
+		return
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, errorInvalidRequest, "token is required")
+		return
+	}
+
+	claims, err := ws.jwtValidator.Validate(token)
+	if err != nil {
+		slog.Debug("Inactive token introspected", "err", err)
+		writeJSON(w, http.StatusOK, &introspectionResponse{Active: false})
+		return
+	}
+	writeJSON(w, http.StatusOK, newIntrospectionResponse(claims))
+}
+
+func (ws *Server) jwksActionHandler(w http.ResponseWriter, _ *http.Request) {
+	marshalled, err := ws.keys.JWKSet().Marshal()
+	if err != nil {
+		slog.Error("Failed to marshal JWKS", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s", marshalled)
 }
@@ -0,0 +1,354 @@
+package http
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/reugn/auth-server/internal/auth"
+	"github.com/reugn/auth-server/internal/config"
+	"github.com/reugn/auth-server/internal/repository"
+)
+
+// newTestServer returns a server using the local repository and the default
+// configuration modified by the configure functions.
+func newTestServer(t *testing.T, configure ...func(*config.Service)) *Server {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeysFromPem(
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(repository.EnvLocalConfigPath, repository.DefaultLocalConfigPath)
+	serviceConfig := config.NewServiceDefault()
+	serviceConfig.SigningMethod = "EdDSA"
+	serviceConfig.ProxyProvider = "traefik"
+	serviceConfig.HTTP.AuthResponseHeaders = map[string]string{"X-Auth-User": "user"}
+	for _, configureFunc := range configure {
+		configureFunc(serviceConfig)
+	}
+	if err = serviceConfig.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer("test", keys, serviceConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+// serve serves the request using the server handler and returns the response.
+func serve(server *Server, request *http.Request) *http.Response {
+	recorder := httptest.NewRecorder()
+	server.handler().ServeHTTP(recorder, request)
+	return recorder.Result()
+}
+
+// issueTestToken issues an access token for the local repository admin user.
+func issueTestToken(t *testing.T, server *Server) string {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, tokenPath, nil)
+	request.SetBasicAuth("admin", "1234")
+	response := serve(server, request)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("failed to issue token: %d", response.StatusCode)
+	}
+	var accessToken auth.AccessToken
+	if err := json.NewDecoder(response.Body).Decode(&accessToken); err != nil {
+		t.Fatal(err)
+	}
+	return accessToken.Token
+}
+
+func TestServer_Auth(t *testing.T) {
+	server := newTestServer(t)
+	token := issueTestToken(t, server)
+
+	revoked := issueTestToken(t, server)
+	form := url.Values{"token": {revoked}}
+	request := httptest.NewRequest(http.MethodPost, revocationPath, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if response := serve(server, request); response.StatusCode != http.StatusOK {
+		t.Fatalf("failed to revoke token: %d", response.StatusCode)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		method        string
+		uri           string
+		status        int
+		challenge     string
+	}{
+		{"authorized", "Bearer " + token, "GET", "/dashboard", http.StatusOK, ""},
+		{"missing-token", "", "GET", "/dashboard", http.StatusUnauthorized,
+			`Bearer realm="auth-server"`},
+		{"basic-credentials", "Basic YWRtaW46MTIzNA==", "GET", "/dashboard", http.StatusUnauthorized,
+			`Bearer realm="auth-server"`},
+		{"malformed-token", "Bearer token", "GET", "/dashboard", http.StatusUnauthorized,
+			`Bearer realm="auth-server", error="invalid_token", error_description="malformed token"`},
+		{"bad-signature", "Bearer " + token[:len(token)-4] + "AAAA", "GET", "/dashboard",
+			http.StatusUnauthorized,
+			`Bearer realm="auth-server", error="invalid_token", error_description="bad signature"`},
+		{"revoked-token", "Bearer " + revoked, "GET", "/dashboard", http.StatusUnauthorized,
+			`Bearer realm="auth-server", error="invalid_token", error_description="token revoked"`},
+		{"no-matching-rule", "Bearer " + token, "GET", "/unknown", http.StatusForbidden,
+			`Bearer realm="auth-server", error="insufficient_scope", error_description="no matching rule"`},
+		{"explicit-deny", "Bearer " + token, "DELETE", "/admin/audit", http.StatusForbidden,
+			`Bearer realm="auth-server", error="insufficient_scope", error_description="explicit deny"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/auth", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			request.Header.Set("X-Forwarded-Method", tt.method)
+			request.Header.Set("X-Forwarded-Uri", tt.uri)
+
+			response := serve(server, request)
+			if response.StatusCode != tt.status {
+				t.Fatalf("unexpected status: %d", response.StatusCode)
+			}
+			if challenge := response.Header.Get("WWW-Authenticate"); challenge != tt.challenge {
+				t.Fatalf("unexpected challenge: %s", challenge)
+			}
+			authorized := response.Header.Get("X-Auth-User") == "admin"
+			if authorized != (tt.status == http.StatusOK) {
+				t.Fatal("unexpected identity header")
+			}
+		})
+	}
+}
+
+func TestServer_AuthHeaders(t *testing.T) {
+	server := newTestServer(t, func(serviceConfig *config.Service) {
+		serviceConfig.HTTP.AuthResponseHeaders = map[string]string{
+			"X-Auth-User":   "user",
+			"X-Auth-Email":  "email",
+			"X-Auth-Tenant": "tenant",
+		}
+	})
+	request := httptest.NewRequest(http.MethodGet, "/auth", nil)
+	request.Header.Set("Authorization", "Bearer "+issueTestToken(t, server))
+	request.Header.Set("X-Forwarded-Method", "GET")
+	request.Header.Set("X-Forwarded-Uri", "/dashboard")
+
+	response := serve(server, request)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", response.StatusCode)
+	}
+	expected := map[string]string{
+		"X-Auth-User":  "admin",
+		"X-Auth-Email": "admin@example.com",
+		// the missing claim is sent empty to override the client-supplied header
+		"X-Auth-Tenant": "",
+	}
+	for header, value := range expected {
+		values, ok := response.Header[header]
+		if !ok || len(values) != 1 || values[0] != value {
+			t.Fatalf("unexpected %s header: %v", header, values)
+		}
+	}
+}
+
+// unavailableRepository fails to fetch the permissions of any role.
+type unavailableRepository struct{}
+
+func (unavailableRepository) AuthenticateBasic(_ string, _ string) *repository.UserDetails {
+	return nil
+}
+
+func (unavailableRepository) LookupUser(_ string) (*repository.UserDetails, error) {
+	return nil, nil
+}
+
+func (unavailableRepository) AuthorizeRequest(userRole repository.UserRole,
+	_ repository.RequestDetails) repository.Decision {
+	return repository.Decision{Reason: repository.ReasonRepositoryError, Role: userRole}
+}
+
+func TestServer_AuthErrors(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "local.yml")
+	if err := os.WriteFile(configPath, []byte(`
+users:
+  admin:
+    # bcrypt hash of "1234"
+    password: "$2a$10$MJRrWom6waW/rpqW6tJGneDqt8X5OBFgRp2qmqqTEVOa3JJODO8pG"
+    role: admin
+roles:
+  admin:
+    - method: GET
+      uri: /dashboard
+      condition: claims.user > 1
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, func(*config.Service) {
+		t.Setenv(repository.EnvLocalConfigPath, configPath)
+	})
+	token := issueTestToken(t, server)
+	authorize := func() *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		request.Header.Set("X-Forwarded-Method", http.MethodGet)
+		request.Header.Set("X-Forwarded-Uri", "/dashboard")
+		return serve(server, request)
+	}
+
+	// the errors are not reported as the lack of permissions
+	response := authorize()
+	if response.StatusCode != http.StatusInternalServerError || response.Header.Get("WWW-Authenticate") != "" {
+		t.Fatalf("unexpected condition error response: %d", response.StatusCode)
+	}
+	server.jwtValidator = auth.NewJWTValidator(server.keys, jwt.SigningMethodEdDSA, auth.ClaimsConfig{},
+		unavailableRepository{}, auth.NewMemoryRevocationStore())
+	response = authorize()
+	if response.StatusCode != http.StatusServiceUnavailable || response.Header.Get("WWW-Authenticate") != "" {
+		t.Fatalf("unexpected repository error response: %d", response.StatusCode)
+	}
+}
+
+func TestServer_Lockout(t *testing.T) {
+	server := newTestServer(t)
+	tokenRequest := func(username, password string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, tokenPath, nil)
+		request.SetBasicAuth(username, password)
+		return serve(server, request)
+	}
+
+	for i := 0; i < 5; i++ {
+		if response := tokenRequest("admin", "0000"); response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("unexpected status: %d", response.StatusCode)
+		}
+	}
+	response := tokenRequest("admin", "1234")
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status of the locked account: %d", response.StatusCode)
+	}
+	// the lockout started during the failed attempts
+	if retryAfter, _ := strconv.Atoi(response.Header.Get("Retry-After")); retryAfter < 1 || retryAfter > 30 {
+		t.Fatalf("unexpected Retry-After: %s", response.Header.Get("Retry-After"))
+	}
+	var oauthErr oauthError
+	if err := json.NewDecoder(response.Body).Decode(&oauthErr); err != nil {
+		t.Fatal(err)
+	}
+	if oauthErr.Code != errorAccountLocked {
+		t.Fatalf("unexpected error: %s", oauthErr.Code)
+	}
+
+	// the lockout is tracked per username
+	if response := tokenRequest("user", "0000"); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status of another account: %d", response.StatusCode)
+	}
+}
+
+func TestServer_RateLimitPolicies(t *testing.T) {
+	server := newTestServer(t, func(serviceConfig *config.Service) {
+		serviceConfig.HTTP.Rate.WhiteList = []string{"10.0.0.1"}
+		serviceConfig.HTTP.Rate.Policies = []config.RateLimitPolicy{
+			// the token issued below consumes the first token of the bucket
+			{Route: tokenPath, Key: config.RateLimitKeyIP, Tps: 0.1, Size: 2},
+			{Route: "/version", Key: config.RateLimitKeyHeader, Header: "X-Api-Key", Tps: 0.1, Size: 1},
+			{Route: "/auth", Key: config.RateLimitKeySubject, Tps: 0.1, Size: 1},
+		}
+	})
+	token := issueTestToken(t, server)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		path       string
+		headers    map[string]string
+		status     int
+	}{
+		{"token", "192.0.2.1:1234", tokenPath, nil, http.StatusBadRequest},
+		{"token-exceeded", "192.0.2.1:1234", tokenPath, nil, http.StatusTooManyRequests},
+		{"token-another-ip", "192.0.2.2:1234", tokenPath, nil, http.StatusBadRequest},
+		{"token-white-listed", "10.0.0.1:1234", tokenPath, nil, http.StatusBadRequest},
+		{"token-white-listed-again", "10.0.0.1:1234", tokenPath, nil, http.StatusBadRequest},
+		{"other-route", "192.0.2.1:1234", "/health", nil, http.StatusOK},
+		{"header", "192.0.2.1:1234", "/version", map[string]string{"X-Api-Key": "a"}, http.StatusOK},
+		{"header-exceeded", "192.0.2.2:1234", "/version", map[string]string{"X-Api-Key": "a"},
+			http.StatusTooManyRequests},
+		{"header-another-value", "192.0.2.1:1234", "/version", map[string]string{"X-Api-Key": "b"},
+			http.StatusOK},
+		{"header-missing", "192.0.2.1:1234", "/version", nil, http.StatusOK},
+		{"subject", "192.0.2.1:1234", "/auth", map[string]string{"Authorization": "Bearer " + token},
+			http.StatusForbidden},
+		{"subject-exceeded", "10.0.0.1:1234", "/auth", map[string]string{"Authorization": "Bearer " + token},
+			http.StatusTooManyRequests},
+		{"subject-invalid-token", "192.0.2.1:1234", "/auth", map[string]string{"Authorization": "Bearer token"},
+			http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			request.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				request.Header.Set(name, value)
+			}
+			response := serve(server, request)
+			if response.StatusCode != tt.status {
+				t.Fatalf("unexpected status: %d", response.StatusCode)
+			}
+			if tt.status == http.StatusTooManyRequests {
+				// the bucket may be partially refilled since the token was issued
+				retryAfter := response.Header.Get("Retry-After")
+				if seconds, err := strconv.Atoi(retryAfter); err != nil || seconds < 1 || seconds > 10 {
+					t.Fatalf("unexpected Retry-After: %s", retryAfter)
+				}
+				if response.Header.Get("RateLimit-Remaining") != "0" ||
+					response.Header.Get("RateLimit-Reset") != retryAfter {
+					t.Fatal("unexpected RateLimit headers")
+				}
+			}
+		})
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range server.rateLimitPolicies {
+		select {
+		case <-policy.limiter.(*IPRateLimiter).stop:
+		default:
+			t.Fatalf("rate limiter of %s is not closed", policy.route)
+		}
+	}
+	if err := server.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
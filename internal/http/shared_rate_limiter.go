@@ -0,0 +1,118 @@
+package http
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sharedRateLimiterRetries is the maximum number of the concurrently modified
+// state updates of a SharedRateLimiter key.
+const sharedRateLimiterRetries = 10
+
+// errRateLimitConflict is returned when the rate limiter state of a key is
+// updated concurrently more than sharedRateLimiterRetries times.
+var errRateLimitConflict = errors.New("rate limit state update conflict")
+
+// RateLimitStore is the store of the rate limiter state shared by the service instances.
+// The values are versioned to be updated optimistically.
+type RateLimitStore interface {
+	// Get returns the value of the key and its version, or the zero version
+	// if the key does not exist.
+	Get(key string) (value int64, version uint32, err error)
+	// CompareAndSet sets the value of the key expiring after the ttl, if the version
+	// of the key is unchanged since read. It reports whether the value is set.
+	CompareAndSet(key string, value int64, version uint32, ttl time.Duration) (bool, error)
+}
+
+// SharedRateLimiter implements the RateLimiter interface using the generic cell rate
+// algorithm (GCRA) over a RateLimitStore, enforcing the limits across the service
+// instances. The state of a key is a single value, the theoretical arrival time of
+// the next request, which expires once the bucket is full again.
+// The instances are expected to have their clocks synchronized.
+type SharedRateLimiter struct {
+	store  RateLimitStore
+	prefix string
+	limit  rate.Limit
+	burst  int
+	// interval is the emission interval, the time to refill a token.
+	interval time.Duration
+}
+
+var _ RateLimiter = (*SharedRateLimiter)(nil)
+
+// NewSharedRateLimiter returns a new SharedRateLimiter of tps tokens per second and
+// the bucket size. The keys are prefixed in the store by the prefix, distinguishing
+// the rate limiters sharing the store.
+func NewSharedRateLimiter(store RateLimitStore, prefix string, tps rate.Limit, size int) *SharedRateLimiter {
+	return &SharedRateLimiter{
+		store:    store,
+		prefix:   prefix,
+		limit:    tps,
+		burst:    size,
+		interval: time.Duration(float64(time.Second) / float64(tps)),
+	}
+}
+
+// Reserve implements the RateLimiter interface.
+func (l *SharedRateLimiter) Reserve(key string, now time.Time) (*RateLimitReservation, error) {
+	key = l.prefix + key
+	for i := 0; i < sharedRateLimiterRetries; i++ {
+		arrival, version, err := l.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		next := time.Unix(0, max(arrival, now.UnixNano())).Add(l.interval)
+		// the request is allowed if the bucket is not emptied by it
+		if allowAt := next.Add(-time.Duration(l.burst) * l.interval); allowAt.After(now) {
+			return &RateLimitReservation{Delay: allowAt.Sub(now)}, nil
+		}
+		ok, err := l.store.CompareAndSet(key, next.UnixNano(), version, next.Sub(now))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &RateLimitReservation{
+				cancel: func() { l.cancel(key, now) },
+			}, nil
+		}
+	}
+	return nil, errRateLimitConflict
+}
+
+// cancel returns the token taken at the time to the bucket of the key.
+func (l *SharedRateLimiter) cancel(key string, now time.Time) {
+	for i := 0; i < sharedRateLimiterRetries; i++ {
+		arrival, version, err := l.store.Get(key)
+		if err != nil || version == 0 {
+			// the bucket is already full if the key has expired
+			logRateLimitCancelError(key, err)
+			return
+		}
+		arrival -= l.interval.Nanoseconds()
+		ok, err := l.store.CompareAndSet(key, arrival, version, time.Unix(0, arrival).Sub(now))
+		if err != nil || ok {
+			logRateLimitCancelError(key, err)
+			return
+		}
+	}
+	logRateLimitCancelError(key, errRateLimitConflict)
+}
+
+func logRateLimitCancelError(key string, err error) {
+	if err != nil {
+		slog.Warn("Failed to cancel rate limit reservation", "key", key, "err", err)
+	}
+}
+
+// Limit implements the RateLimiter interface.
+func (l *SharedRateLimiter) Limit() rate.Limit {
+	return l.limit
+}
+
+// Burst implements the RateLimiter interface.
+func (l *SharedRateLimiter) Burst() int {
+	return l.burst
+}
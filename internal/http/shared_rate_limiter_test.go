@@ -0,0 +1,286 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/reugn/auth-server/internal/config"
+)
+
+// memoryRateLimitStore is an in-process fake of the shared RateLimitStore.
+// The expiration is evaluated using the now function.
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]*memoryRateLimitEntry
+	err     error
+}
+
+type memoryRateLimitEntry struct {
+	value   int64
+	version uint32
+	expires time.Time
+}
+
+var _ RateLimitStore = (*memoryRateLimitStore)(nil)
+
+func newMemoryRateLimitStore(now func() time.Time) *memoryRateLimitStore {
+	return &memoryRateLimitStore{
+		now:     now,
+		entries: make(map[string]*memoryRateLimitEntry),
+	}
+}
+
+func (s *memoryRateLimitStore) Get(key string) (int64, uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, 0, s.err
+	}
+	entry := s.entry(key)
+	if entry == nil {
+		return 0, 0, nil
+	}
+	return entry.value, entry.version, nil
+}
+
+func (s *memoryRateLimitStore) CompareAndSet(key string, value int64, version uint32,
+	ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	entry := s.entry(key)
+	var current uint32
+	if entry != nil {
+		current = entry.version
+	}
+	if current != version {
+		return false, nil
+	}
+	s.entries[key] = &memoryRateLimitEntry{
+		value:   value,
+		version: version + 1,
+		expires: s.now().Add(ttl),
+	}
+	return true, nil
+}
+
+// entry returns the unexpired entry of the key.
+func (s *memoryRateLimitStore) entry(key string) *memoryRateLimitEntry {
+	entry, ok := s.entries[key]
+	if !ok || !s.now().Before(entry.expires) {
+		delete(s.entries, key)
+		return nil
+	}
+	return entry
+}
+
+func TestSharedRateLimiter_Reserve(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newMemoryRateLimitStore(func() time.Time { return now })
+	limiter := NewSharedRateLimiter(store, "test:", 2, 3)
+
+	for i := 0; i < 3; i++ {
+		reservation, err := limiter.Reserve("k1", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reservation.OK() {
+			t.Fatalf("request %d is rate limited", i)
+		}
+	}
+	reservation, err := limiter.Reserve("k1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reservation.OK() || reservation.Delay != 500*time.Millisecond {
+		t.Fatalf("unexpected delay: %s", reservation.Delay)
+	}
+	if reservation, _ = limiter.Reserve("k2", now); !reservation.OK() {
+		t.Fatal("keys share the bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if reservation, _ = limiter.Reserve("k1", now); !reservation.OK() {
+		t.Fatal("token is not refilled")
+	}
+	if reservation, _ = limiter.Reserve("k1", now); reservation.OK() {
+		t.Fatal("request is not rate limited")
+	}
+
+	// the state expires once the bucket is full again
+	now = now.Add(1500 * time.Millisecond)
+	if len(store.entries) != 2 {
+		t.Fatalf("unexpected number of keys: %d", len(store.entries))
+	}
+	if _, version, _ := store.Get("test:k1"); version != 0 {
+		t.Fatal("state is not expired")
+	}
+}
+
+func TestSharedRateLimiter_Cancel(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newMemoryRateLimitStore(func() time.Time { return now })
+	limiter := NewSharedRateLimiter(store, "", 1, 2)
+
+	first, _ := limiter.Reserve("key", now)
+	second, _ := limiter.Reserve("key", now)
+	if !first.OK() || !second.OK() {
+		t.Fatal("request is rate limited")
+	}
+	second.Cancel()
+	if reservation, _ := limiter.Reserve("key", now); !reservation.OK() {
+		t.Fatal("token is not returned")
+	}
+	if reservation, _ := limiter.Reserve("key", now); reservation.OK() {
+		t.Fatal("request is not rate limited")
+	}
+}
+
+func TestSharedRateLimiter_Instances(t *testing.T) {
+	store := newMemoryRateLimitStore(time.Now)
+	// the limiters of the service instances sharing the store
+	instances := []RateLimiter{
+		NewSharedRateLimiter(store, "default:", 0.001, 10),
+		NewSharedRateLimiter(store, "default:", 0.001, 10),
+		NewSharedRateLimiter(store, "default:", 0.001, 10),
+	}
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for _, limiter := range instances {
+		wg.Add(1)
+		go func(limiter RateLimiter) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				reservation, err := limiter.Reserve("10.0.0.1", time.Now())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if reservation.OK() {
+					allowed.Add(1)
+				}
+			}
+		}(limiter)
+	}
+	wg.Wait()
+	if allowed.Load() != 10 {
+		t.Fatalf("unexpected number of allowed requests: %d", allowed.Load())
+	}
+}
+
+// conflictRateLimitStore is a RateLimitStore modified concurrently on every update.
+type conflictRateLimitStore struct{}
+
+func (conflictRateLimitStore) Get(string) (int64, uint32, error) {
+	return 0, 0, nil
+}
+
+func (conflictRateLimitStore) CompareAndSet(string, int64, uint32, time.Duration) (bool, error) {
+	return false, nil
+}
+
+func TestSharedRateLimiter_Conflict(t *testing.T) {
+	limiter := NewSharedRateLimiter(conflictRateLimitStore{}, "", 1, 1)
+	if _, err := limiter.Reserve("key", time.Now()); !errors.Is(err, errRateLimitConflict) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServer_SharedRateLimit(t *testing.T) {
+	store := newMemoryRateLimitStore(time.Now)
+	// the instances list the policies in a different order
+	policies := []config.RateLimitPolicy{
+		{Route: "/version", Key: config.RateLimitKeyHeader, Header: "X-Api-Key", Tps: 0.1, Size: 1},
+		{Route: "/health", Key: config.RateLimitKeyIP, Tps: 0.1, Size: 2},
+	}
+	newInstance := func(policies ...config.RateLimitPolicy) *Server {
+		server := newTestServer(t)
+		rateConfig := config.NewHTTPDefault().Rate
+		rateConfig.Policies = policies
+		server.Close()
+		server.rateLimitPolicies = newRateLimitPolicies(&rateConfig, store)
+		return server
+	}
+	instances := []*Server{newInstance(policies...), newInstance(policies[1], policies[0])}
+	health := func(server *Server) int {
+		return serve(server, httptest.NewRequest(http.MethodGet, "/health", nil)).StatusCode
+	}
+
+	if health(instances[0]) != http.StatusOK || health(instances[1]) != http.StatusOK {
+		t.Fatal("request is rate limited")
+	}
+	response := serve(instances[0], httptest.NewRequest(http.MethodGet, "/health", nil))
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatal("instances do not share the rate limit")
+	}
+	if response.Header.Get("RateLimit-Policy") != "2;w=20" {
+		t.Fatalf("unexpected policy: %s", response.Header.Get("RateLimit-Policy"))
+	}
+	version := func(server *Server) int {
+		request := httptest.NewRequest(http.MethodGet, "/version", nil)
+		request.Header.Set("X-Api-Key", "key")
+		return serve(server, request).StatusCode
+	}
+	if version(instances[1]) != http.StatusOK || version(instances[0]) != http.StatusTooManyRequests {
+		t.Fatal("instances do not share the rate limit")
+	}
+
+	// the requests are not limited if the store is unavailable
+	store.err = errors.New("store is unavailable")
+	if health(instances[1]) != http.StatusOK {
+		t.Fatal("request is rate limited on the store error")
+	}
+}
+
+func TestServer_SubjectRateLimitOrder(t *testing.T) {
+	store := newMemoryRateLimitStore(time.Now)
+	server := newTestServer(t)
+	token := issueTestToken(t, server)
+	rateConfig := config.NewHTTPDefault().Rate
+	rateConfig.Policies = []config.RateLimitPolicy{
+		{Route: "/auth", Key: config.RateLimitKeySubject, Tps: 0.1, Size: 10},
+		{Route: "/auth", Key: config.RateLimitKeyIP, Tps: 0.1, Size: 1},
+	}
+	server.Close()
+	server.rateLimitPolicies = newRateLimitPolicies(&rateConfig, store)
+	if last := server.rateLimitPolicies[len(server.rateLimitPolicies)-1]; last.key != config.RateLimitKeySubject {
+		t.Fatalf("unexpected last policy: %s", last.key)
+	}
+
+	subjectKeys := func() int {
+		var n int
+		for key := range store.entries {
+			if strings.HasPrefix(key, "/auth subject :") {
+				n++
+			}
+		}
+		return n
+	}
+	auth := func() int {
+		request := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		return serve(server, request).StatusCode
+	}
+	if auth() == http.StatusTooManyRequests || subjectKeys() != 1 {
+		t.Fatal("request is not limited by the subject")
+	}
+	store.entries = make(map[string]*memoryRateLimitEntry)
+	store.entries["/auth ip :192.0.2.1"] = &memoryRateLimitEntry{
+		value:   time.Now().Add(time.Minute).UnixNano(),
+		version: 1,
+		expires: time.Now().Add(time.Minute),
+	}
+	// the token of the request rejected by the ip policy is not verified
+	if auth() != http.StatusTooManyRequests || subjectKeys() != 0 {
+		t.Fatal("subject policy is applied to the rejected request")
+	}
+}
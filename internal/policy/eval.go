@@ -0,0 +1,324 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// node represents a node of the expression syntax tree.
+type node interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n *literalNode) eval(_ map[string]any) (any, error) {
+	return n.value, nil
+}
+
+type identNode struct {
+	name string
+}
+
+func (n *identNode) eval(vars map[string]any) (any, error) {
+	value, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable: %s", n.name)
+	}
+	return normalize(value), nil
+}
+
+type memberNode struct {
+	target node
+	name   string
+}
+
+// eval returns the value of the map entry, or null if either the entry or the map is missing.
+func (n *memberNode) eval(vars map[string]any) (any, error) {
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return lookup(target, n.name)
+}
+
+type indexNode struct {
+	target node
+	key    node
+}
+
+func (n *indexNode) eval(vars map[string]any) (any, error) {
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case string:
+		return lookup(target, key)
+	case float64:
+		list, ok := target.([]any)
+		if !ok {
+			if target == nil {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("cannot index %T with a number", target)
+		}
+		index := int(key)
+		if float64(index) != key || index < 0 || index >= len(list) {
+			return nil, nil
+		}
+		return normalize(list[index]), nil
+	default:
+		return nil, fmt.Errorf("invalid index type: %T", key)
+	}
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(vars map[string]any) (any, error) {
+	list := make([]any, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = value
+	}
+	return list, nil
+}
+
+type callNode struct {
+	name     string
+	function func(args []any) (any, error)
+	args     []node
+}
+
+func (n *callNode) eval(vars map[string]any) (any, error) {
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	result, err := n.function(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return result, nil
+}
+
+type unaryNode struct {
+	operator string
+	operand  node
+}
+
+func (n *unaryNode) eval(vars map[string]any) (any, error) {
+	operand, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.operator {
+	case "!":
+		value, ok := operand.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator ! expects a bool, got %T", operand)
+		}
+		return !value, nil
+	default:
+		value, ok := operand.(float64)
+		if !ok {
+			return nil, fmt.Errorf("operator - expects a number, got %T", operand)
+		}
+		return -value, nil
+	}
+}
+
+type binaryNode struct {
+	operator string
+	left     node
+	right    node
+}
+
+func (n *binaryNode) eval(vars map[string]any) (any, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// the logical operators short-circuit
+	if n.operator == "&&" || n.operator == "||" {
+		value, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s expects a bool, got %T", n.operator, left)
+		}
+		if value == (n.operator == "||") {
+			return value, nil
+		}
+		right, err := n.right.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		if value, ok = right.(bool); !ok {
+			return nil, fmt.Errorf("operator %s expects a bool, got %T", n.operator, right)
+		}
+		return value, nil
+	}
+
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	// a missing value is neither equal nor unequal to another value, so the
+	// comparisons of two missing values do not hold; null is checked explicitly
+	if (left == nil || right == nil) && (n.operator == "==" || n.operator == "!=") &&
+		!isNullLiteral(n.left) && !isNullLiteral(n.right) {
+		return false, nil
+	}
+	switch n.operator {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left)
+	default:
+		return compare(n.operator, left, right)
+	}
+}
+
+// isNullLiteral reports whether the node is the null literal.
+func isNullLiteral(n node) bool {
+	literal, ok := n.(*literalNode)
+	return ok && literal.value == nil
+}
+
+// lookup returns the map entry by key, the missing entries are null.
+func lookup(target any, key string) (any, error) {
+	switch target := target.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return normalize(target[key]), nil
+	case map[string]string:
+		if value, ok := target[key]; ok {
+			return value, nil
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("cannot access %s of %T", key, target)
+	}
+}
+
+// normalize converts the numeric and list values to the types used by the evaluator.
+func normalize(value any) any {
+	switch value := value.(type) {
+	case int:
+		return float64(value)
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	case json.Number:
+		number, err := value.Float64()
+		if err != nil {
+			return value.String()
+		}
+		return number
+	case []string:
+		list := make([]any, len(value))
+		for i, item := range value {
+			list[i] = item
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+func equal(left any, right any) bool {
+	return reflect.DeepEqual(normalize(left), normalize(right))
+}
+
+// compare compares the numbers or the strings, the comparison with null is false.
+func compare(operator string, left any, right any) (bool, error) {
+	if left == nil || right == nil {
+		return false, nil
+	}
+	var result int
+	switch leftValue := left.(type) {
+	case float64:
+		rightValue, ok := right.(float64)
+		if !ok {
+			return false, fmt.Errorf("cannot compare number with %T", right)
+		}
+		switch {
+		case leftValue < rightValue:
+			result = -1
+		case leftValue > rightValue:
+			result = 1
+		}
+	case string:
+		rightValue, ok := right.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot compare string with %T", right)
+		}
+		result = strings.Compare(leftValue, rightValue)
+	default:
+		return false, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+	switch operator {
+	case "<":
+		return result < 0, nil
+	case "<=":
+		return result <= 0, nil
+	case ">":
+		return result > 0, nil
+	default:
+		return result >= 0, nil
+	}
+}
+
+// contains reports whether the list contains the value, the map contains
+// the key or the string contains the substring.
+func contains(container any, value any) (bool, error) {
+	switch container := container.(type) {
+	case nil:
+		return false, nil
+	case []any:
+		for _, item := range container {
+			if equal(item, value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]any, map[string]string:
+		key, ok := value.(string)
+		if !ok {
+			return false, fmt.Errorf("map key must be a string, got %T", value)
+		}
+		entry, err := lookup(container, key)
+		return entry != nil, err
+	case string:
+		substr, ok := value.(string)
+		if !ok {
+			return false, fmt.Errorf("substring must be a string, got %T", value)
+		}
+		return strings.Contains(container, substr), nil
+	default:
+		return false, fmt.Errorf("operator in expects a list, map or string, got %T", container)
+	}
+}
@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// function represents a built-in function of the expression language.
+type function struct {
+	arity int
+	call  func(args []any) (any, error)
+}
+
+// functions contains the built-in functions.
+var functions = map[string]function{
+	"startsWith": {2, stringsFunction(func(s []string) any {
+		return strings.HasPrefix(s[0], s[1])
+	})},
+	"endsWith": {2, stringsFunction(func(s []string) any {
+		return strings.HasSuffix(s[0], s[1])
+	})},
+	"lower": {1, stringsFunction(func(s []string) any {
+		return strings.ToLower(s[0])
+	})},
+	"upper": {1, stringsFunction(func(s []string) any {
+		return strings.ToUpper(s[0])
+	})},
+	"matches":   {2, matches},
+	"size":      {1, size},
+	"inNetwork": {2, inNetwork},
+}
+
+// stringsFunction wraps the function accepting string arguments only.
+func stringsFunction(f func(s []string) any) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		values := make([]string, len(args))
+		for i, arg := range args {
+			value, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("argument %d must be a string, got %T", i+1, arg)
+			}
+			values[i] = value
+		}
+		return f(values), nil
+	}
+}
+
+// matches reports whether the string contains a match of the regular expression.
+func matches(args []any) (any, error) {
+	value, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument 1 must be a string, got %T", args[0])
+	}
+	expr, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument 2 must be a string, got %T", args[1])
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return pattern.MatchString(value), nil
+}
+
+// inNetwork reports whether the IP address belongs to the CIDR network.
+// An invalid address, e.g. an empty string, does not belong to any network.
+func inNetwork(args []any) (any, error) {
+	ip, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument 1 must be a string, got %T", args[0])
+	}
+	cidr, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument 2 must be a string, got %T", args[1])
+	}
+	network, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, nil
+	}
+	return network.Contains(addr.Unmap()), nil
+}
+
+// size returns the length of the string, list or map.
+func size(args []any) (any, error) {
+	switch value := args[0].(type) {
+	case string:
+		return float64(len(value)), nil
+	case []any:
+		return float64(len(value)), nil
+	case map[string]any:
+		return float64(len(value)), nil
+	case map[string]string:
+		return float64(len(value)), nil
+	case nil:
+		return float64(0), nil
+	default:
+		return nil, fmt.Errorf("size of %T is undefined", value)
+	}
+}
@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind represents the kind of a lexical token.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+// token represents a lexical token of an expression.
+type token struct {
+	kind  tokenKind
+	text  string
+	value any
+	pos   int
+}
+
+// operators contains the supported operators and punctuation,
+// the two-character operators precede their prefixes.
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=",
+	"<", ">", "!", "-", "(", ")", "[", "]", ",", "."}
+
+// tokenize splits the expression into tokens.
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(expr); {
+		c := rune(expr[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case c == '_' || unicode.IsLetter(c):
+			start := pos
+			for pos < len(expr) && isIdentChar(rune(expr[pos])) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: expr[start:pos], pos: start})
+		case unicode.IsDigit(c):
+			start := pos
+			for pos < len(expr) && (unicode.IsDigit(rune(expr[pos])) || expr[pos] == '.') {
+				pos++
+			}
+			number, err := strconv.ParseFloat(expr[start:pos], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number at %d: %s", start, expr[start:pos])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: expr[start:pos],
+				value: number, pos: start})
+		case c == '"' || c == '\'':
+			value, end, err := scanString(expr, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: expr[pos:end],
+				value: value, pos: pos})
+			pos = end
+		default:
+			operator := matchOperator(expr[pos:])
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character at %d: %q", pos, c)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: pos})
+			pos += len(operator)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func matchOperator(expr string) string {
+	for _, operator := range operators {
+		if strings.HasPrefix(expr, operator) {
+			return operator
+		}
+	}
+	return ""
+}
+
+// scanString scans the quoted string literal starting at the position.
+// It returns the unescaped value and the position after the closing quote.
+func scanString(expr string, start int) (string, int, error) {
+	quote := expr[start]
+	var value strings.Builder
+	for pos := start + 1; pos < len(expr); pos++ {
+		switch expr[pos] {
+		case quote:
+			return value.String(), pos + 1, nil
+		case '\\':
+			pos++
+			if pos == len(expr) {
+				break
+			}
+			switch expr[pos] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(expr[pos])
+			}
+		default:
+			value.WriteByte(expr[pos])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string at %d", start)
+}
@@ -0,0 +1,231 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+)
+
+// variableNames contains the names of the expression variables.
+var variableNames = []string{"claims", "request", "time"}
+
+// binaryPrecedence contains the precedence of the binary operators,
+// the higher binds tighter.
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3,
+	"!=": 3,
+	"<":  4,
+	"<=": 4,
+	">":  4,
+	">=": 4,
+	"in": 4,
+}
+
+// parser is a recursive descent parser producing the expression syntax tree.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse parses the expression into a syntax tree.
+func parse(expr string) (node, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected token at %d: %s", next.pos, next.text)
+	}
+	return root, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator.
+func (p *parser) accept(operator string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.text == operator {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(operator string) error {
+	if !p.accept(operator) {
+		t := p.peek()
+		return fmt.Errorf("expected %s at %d", operator, t.pos)
+	}
+	return nil
+}
+
+// binaryOperator returns the binary operator of the next token and its precedence.
+func (p *parser) binaryOperator() (string, int) {
+	t := p.peek()
+	if t.kind != tokenOperator && t.kind != tokenIdent {
+		return "", 0
+	}
+	precedence, ok := binaryPrecedence[t.text]
+	if !ok {
+		return "", 0
+	}
+	return t.text, precedence
+}
+
+// parseBinary parses the binary operations with the precedence of at least minPrecedence.
+func (p *parser) parseBinary(minPrecedence int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, precedence := p.binaryOperator()
+		if precedence < minPrecedence || precedence == 0 {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	for _, operator := range []string{"!", "-"} {
+		if p.accept(operator) {
+			operand, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryNode{operator: operator, operand: operand}, nil
+		}
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses the member access and index operations.
+func (p *parser) parsePostfix() (node, error) {
+	target, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokenIdent {
+				return nil, fmt.Errorf("expected member name at %d", name.pos)
+			}
+			target = &memberNode{target: target, name: name.text}
+		case p.accept("["):
+			key, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			target = &indexNode{target: target, key: key}
+		default:
+			return target, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber, tokenString:
+		return &literalNode{value: t.value}, nil
+	case tokenIdent:
+		return p.parseIdent(t)
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			inner, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items: items}, nil
+		}
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected token at %d: %s", t.pos, t.text)
+}
+
+func (p *parser) parseIdent(t token) (node, error) {
+	switch t.text {
+	case "true":
+		return &literalNode{value: true}, nil
+	case "false":
+		return &literalNode{value: false}, nil
+	case "null":
+		return &literalNode{value: nil}, nil
+	case "in":
+		return nil, fmt.Errorf("unexpected operator at %d: in", t.pos)
+	}
+	if !p.accept("(") {
+		if !slices.Contains(variableNames, t.text) {
+			return nil, fmt.Errorf("unknown variable at %d: %s", t.pos, t.text)
+		}
+		return &identNode{name: t.text}, nil
+	}
+	function, ok := functions[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function at %d: %s", t.pos, t.text)
+	}
+	args, err := p.parseList(")")
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != function.arity {
+		return nil, fmt.Errorf("function %s expects %d arguments, got %d",
+			t.text, function.arity, len(args))
+	}
+	return &callNode{name: t.text, function: function.call, args: args}, nil
+}
+
+// parseList parses the comma-separated expressions until the closing operator.
+func (p *parser) parseList(closing string) ([]node, error) {
+	items := make([]node, 0)
+	if p.accept(closing) {
+		return items, nil
+	}
+	for {
+		item, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.accept(closing) {
+			return items, nil
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package policy implements a small expression language used to express
+// the permission conditions over the token claims, the request and time.
+//
+// An expression consists of:
+//   - literals: strings in single or double quotes, numbers, true, false, null and lists [1, 2]
+//   - variables: claims, request and time, and member access claims.tenant or request.headers["x-tenant"]
+//   - operators: ||, &&, !, ==, !=, <, <=, >, >=, in and parentheses
+//   - functions: startsWith, endsWith, lower, upper, matches, size and inNetwork
+//
+// The missing map entries evaluate to null. The comparisons with null are false,
+// including == and !=, unless an operand is the null literal, e.g. claims.tenant != null.
+// An expression must evaluate to a bool.
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Expression represents a parsed condition expression.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse parses the condition expression.
+func Parse(expr string) (*Expression, error) {
+	root, err := parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expr, err)
+	}
+	return &Expression{source: expr, root: root}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Evaluate evaluates the expression using the input.
+// It returns an error if the expression does not evaluate to a bool.
+func (e *Expression) Evaluate(input *Input) (bool, error) {
+	value, err := e.root.eval(input.variables())
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q evaluated to %T, expected bool", e.source, value)
+	}
+	return result, nil
+}
+
+// Input contains the request attributes the expression is evaluated against.
+type Input struct {
+	Method  string
+	URI     string
+	Headers http.Header
+	Query   url.Values
+	IP      string
+	Claims  map[string]any
+	Time    time.Time
+}
+
+// variables returns the expression variables:
+//   - claims: the token claims
+//   - request: method, uri, path, ip, headers and query, the header names are in lower case
+//     and the multiple values are comma-separated
+//   - time: hour, minute, weekday (0 is Sunday), clock ("15:04"), date ("2006-01-02")
+//     and unix, in the server time zone
+func (in *Input) variables() map[string]any {
+	path, _, _ := strings.Cut(in.URI, "?")
+	headers := make(map[string]any, len(in.Headers))
+	for name, values := range in.Headers {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	query := make(map[string]any, len(in.Query))
+	for name, values := range in.Query {
+		query[name] = strings.Join(values, ",")
+	}
+	now := in.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	claims := in.Claims
+	if claims == nil {
+		claims = map[string]any{}
+	}
+	return map[string]any{
+		"claims": claims,
+		"request": map[string]any{
+			"method":  in.Method,
+			"uri":     in.URI,
+			"path":    path,
+			"ip":      in.IP,
+			"headers": headers,
+			"query":   query,
+		},
+		"time": map[string]any{
+			"hour":    float64(now.Hour()),
+			"minute":  float64(now.Minute()),
+			"weekday": float64(now.Weekday()),
+			"clock":   now.Format("15:04"),
+			"date":    now.Format(time.DateOnly),
+			"unix":    float64(now.Unix()),
+		},
+	}
+}
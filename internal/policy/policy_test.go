@@ -0,0 +1,152 @@
+package policy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+var testInput = &Input{
+	Method:  "GET",
+	URI:     "/api/items?page=2",
+	Headers: http.Header{"X-Tenant": {"t1"}, "Accept": {"text/html", "application/json"}},
+	Query:   url.Values{"page": {"2"}},
+	IP:      "10.1.2.3",
+	Claims: map[string]any{
+		"user":   "admin",
+		"tenant": "t1",
+		"roles":  []any{"admin", "viewer"},
+		"level":  float64(3),
+		"org":    map[string]any{"id": "o1"},
+	},
+	Time: time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC),
+}
+
+func TestExpression_Evaluate(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`true`, true},
+		{`!false`, true},
+		{`claims.tenant == request.headers["x-tenant"]`, true},
+		{`claims.tenant != "t1"`, false},
+		{`claims.user == 'admin' && request.method == "GET"`, true},
+		{`claims.user == "guest" || request.method == "GET"`, true},
+		{`claims.user == "guest" || request.method == "POST"`, false},
+		{`!(claims.user == "admin")`, false},
+		{`"admin" in claims.roles`, true},
+		{`"editor" in claims.roles`, false},
+		{`request.method in ["GET", "HEAD"]`, true},
+		{`"tenant" in claims`, true},
+		{`"api" in request.path`, true},
+		{`claims.level >= 3 && claims.level < 4.5`, true},
+		{`claims.level > -1`, true},
+		{`claims.roles[0] == "admin"`, true},
+		{`claims.roles[5] == null`, true},
+		{`claims.org.id == "o1"`, true},
+		{`claims.missing.id == null`, true},
+		{`claims.missing > 1 || claims.missing <= 1`, false},
+		// the comparisons of the missing values do not hold
+		{`claims.missing == request.headers["x-missing"]`, false},
+		{`claims.missing != request.headers["x-missing"]`, false},
+		{`claims.missing != "t1"`, false},
+		{`claims.missing != null`, false},
+		{`claims.tenant != null && claims.tenant == request.headers["x-tenant"]`, true},
+		{`request.query.page == "2"`, true},
+		{`request.path == "/api/items"`, true},
+		{`request.headers.accept == "text/html,application/json"`, true},
+		{`time.hour >= 8 && time.hour < 20`, true},
+		{`time.clock >= "08:00" && time.clock < "09:00"`, false},
+		{`time.weekday == 1 && time.date == "2024-01-15"`, true},
+		{`startsWith(request.path, "/api/") && endsWith(request.path, "items")`, true},
+		{`lower(request.headers["x-tenant"]) == "t1" && upper(claims.user) == "ADMIN"`, true},
+		{`matches(claims.user, "^ad[a-z]+$")`, true},
+		{`size(claims.roles) == 2 && size(claims.user) == 5`, true},
+		{`request.ip == "10.1.2.3" && inNetwork(request.ip, "10.0.0.0/8")`, true},
+		{`inNetwork(request.ip, "192.168.0.0/16") || inNetwork(claims.user, "10.0.0.0/8")`, false},
+		// short-circuit skips the invalid operand
+		{`false && claims.user > 1`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expression, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := expression.Evaluate(testInput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.expected {
+				t.Fatalf("expected %t", tt.expected)
+			}
+		})
+	}
+}
+
+func TestExpression_EvaluateMissing(t *testing.T) {
+	expression, err := Parse(`claims.tenant == request.headers["x-tenant"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// neither the claim nor the header is present
+	result, err := expression.Evaluate(&Input{Method: "GET", URI: "/x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result {
+		t.Fatal("missing values are equal")
+	}
+}
+
+func TestExpression_EvaluateError(t *testing.T) {
+	tests := []string{
+		`claims.user`,
+		`claims.user > 1`,
+		`claims.level && true`,
+		`!claims.user`,
+		`claims.user.name == "admin"`,
+		`matches(claims.user, "(")`,
+		`startsWith(claims.level, "3")`,
+		`1 in claims.level`,
+		`inNetwork(request.ip, "10.0.0.0")`,
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			expression, err := Parse(expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = expression.Evaluate(testInput); err == nil {
+				t.Fatal("expected evaluation error")
+			}
+		})
+	}
+}
+
+func TestParse_Error(t *testing.T) {
+	tests := []string{
+		``,
+		`claims.user ==`,
+		`(claims.user == "admin"`,
+		`claims.user == "admin`,
+		`claims.`,
+		`user == "admin"`,
+		`unknown(claims.user)`,
+		`lower(claims.user, "a")`,
+		`claims.user = "admin"`,
+		`claims.roles[0`,
+		`1.2.3 == 1`,
+		`claims.user == "admin" true`,
+		`in claims`,
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := Parse(expr); err == nil {
+				t.Fatal("expected parse error")
+			}
+		})
+	}
+}
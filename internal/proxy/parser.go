@@ -1,7 +1,10 @@
 package proxy
 
 import (
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/reugn/auth-server/internal/repository"
 )
@@ -15,3 +18,13 @@ type RequestParser interface {
 	// ParseRequestDetails parses and returns a RequestDetails from the original request.
 	ParseRequestDetails(r *http.Request) *repository.RequestDetails
 }
+
+// parseQuery parses the query parameters of the request URI.
+func parseQuery(uri string) url.Values {
+	_, rawQuery, _ := strings.Cut(uri, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		slog.Debug("Invalid request query", "uri", uri, "err", err)
+	}
+	return query
+}
@@ -35,7 +35,9 @@ func (sp *SimpleParser) ParseAuthorizationToken(r *http.Request) string {
 // ParseRequestDetails parses and returns a RequestDetails from the original request.
 func (sp *SimpleParser) ParseRequestDetails(r *http.Request) *repository.RequestDetails {
 	return &repository.RequestDetails{
-		Method: r.Method,
-		URI:    r.URL.RequestURI(),
+		Method:  r.Method,
+		URI:     r.URL.RequestURI(),
+		Headers: r.Header,
+		Query:   r.URL.Query(),
 	}
 }
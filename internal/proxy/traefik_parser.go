@@ -34,8 +34,11 @@ func (tp *TraefikParser) ParseAuthorizationToken(r *http.Request) string {
 
 // ParseRequestDetails parses and returns a RequestDetails from the original request.
 func (tp *TraefikParser) ParseRequestDetails(r *http.Request) *repository.RequestDetails {
+	uri := r.Header.Get("X-Forwarded-Uri")
 	return &repository.RequestDetails{
-		Method: r.Header.Get("X-Forwarded-Method"),
-		URI:    r.Header.Get("X-Forwarded-Uri"),
+		Method:  r.Header.Get("X-Forwarded-Method"),
+		URI:     uri,
+		Headers: r.Header,
+		Query:   parseQuery(uri),
 	}
 }
@@ -5,6 +5,7 @@ import (
 
 	as "github.com/aerospike/aerospike-client-go/v7"
 	"github.com/reugn/auth-server/internal/util/env"
+	"github.com/reugn/auth-server/internal/util/hash"
 )
 
 // Environment variables to configure AerospikeRepository.
@@ -15,6 +16,7 @@ const (
 	envAerospikeSet       = "AUTH_SERVER_AEROSPIKE_SETNAME"
 	envAerospikeBasicKey  = "AUTH_SERVER_AEROSPIKE_BASIC_KEY"
 	envAerospikeAuthKey   = "AUTH_SERVER_AEROSPIKE_AUTHORIZATION_KEY"
+	envAerospikeClientKey = "AUTH_SERVER_AEROSPIKE_CLIENT_KEY"
 )
 
 // aerospikeConfig contains AerospikeRepository configuration properties.
@@ -25,18 +27,27 @@ type aerospikeConfig struct {
 	setName          string
 	basicAuthKey     string
 	authorizationKey string
+	clientKey        string
 }
 
 // AerospikeRepository implements the Repository interface using Aerospike Database
 // as the storage backend.
 type AerospikeRepository struct {
-	client  *as.Client
-	config  aerospikeConfig
-	baseKey *as.Key
-	authKey *as.Key
+	client    *as.Client
+	hasher    hash.PasswordHasher
+	config    aerospikeConfig
+	baseKey   *as.Key
+	authKey   *as.Key
+	clientKey *as.Key
+	// permissions caches the compiled role permissions.
+	permissions *permissionCache
 }
 
-var _ Repository = (*AerospikeRepository)(nil)
+var (
+	_ Repository      = (*AerospikeRepository)(nil)
+	_ ClientRegistry  = (*AerospikeRepository)(nil)
+	_ PasswordUpdater = (*AerospikeRepository)(nil)
+)
 
 func getAerospikeConfig() aerospikeConfig {
 	// set defaults
@@ -47,6 +58,7 @@ func getAerospikeConfig() aerospikeConfig {
 		setName:          "auth",
 		basicAuthKey:     "basic",
 		authorizationKey: "authorization",
+		clientKey:        "clients",
 	}
 
 	// read configuration from environment variables
@@ -56,12 +68,14 @@ func getAerospikeConfig() aerospikeConfig {
 	env.ReadString(&config.setName, envAerospikeSet)
 	env.ReadString(&config.basicAuthKey, envAerospikeBasicKey)
 	env.ReadString(&config.authorizationKey, envAerospikeAuthKey)
+	env.ReadString(&config.clientKey, envAerospikeClientKey)
 
 	return config
 }
 
 // NewAerospike returns a new AerospikeRepository using environment variables for configuration.
-func NewAerospike() (*AerospikeRepository, error) {
+// The hasher verifies the password hashes and upgrades the outdated ones.
+func NewAerospike(hasher hash.PasswordHasher) (*AerospikeRepository, error) {
 	config := getAerospikeConfig() // read configuration
 	client, err := as.NewClient(config.hostname, config.port)
 	if err != nil {
@@ -75,47 +89,113 @@ func NewAerospike() (*AerospikeRepository, error) {
 	if err != nil {
 		return nil, err
 	}
+	clientKey, err := as.NewKey(config.namespase, config.setName, config.clientKey)
+	if err != nil {
+		return nil, err
+	}
 
 	return &AerospikeRepository{
-		client:  client,
-		config:  config,
-		baseKey: baseKey,
-		authKey: authKey,
+		client:    client,
+		hasher:    hasher,
+		config:    config,
+		baseKey:   baseKey,
+		authKey:   authKey,
+		clientKey: clientKey,
+
+		permissions: newPermissionCache(),
 	}, nil
 }
 
 // AuthenticateBasic validates the basic username and password before issuing a JWT.
-// It uses the bcrypt password-hashing function to validate the password.
+// The outdated password hash is upgraded on successful authentication.
 func (aero *AerospikeRepository) AuthenticateBasic(username string, password string) *UserDetails {
-	record, err := aero.client.Get(nil, aero.baseKey, username)
+	userBin, err := aero.fetchUser(username)
 	if err != nil {
 		slog.Error("Failed to fetch record", "key", aero.baseKey, "err", err)
 		return nil
 	}
-
-	// Bin(user1: {username: user1, password: sha256, role: admin})
-	userBin := record.Bins[username].(map[string]interface{})
+	if userBin == nil {
+		slog.Debug("User not found", "user", username)
+		return nil
+	}
 	hashed, ok := userBin["password"].(string)
-	if !ok || !pwdMatch(hashed, password) {
+	if !ok || !pwdMatch(aero.hasher, hashed, password) {
 		slog.Debug("Failed to authenticate", "user", username)
 		return nil
 	}
+	rehashPassword(aero.hasher, aero, username, hashed, password)
+
+	return toUserDetails(username, userBin)
+}
+
+// LookupUser returns the details of the user without verifying the password.
+func (aero *AerospikeRepository) LookupUser(username string) (*UserDetails, error) {
+	userBin, err := aero.fetchUser(username)
+	if err != nil || userBin == nil {
+		return nil, err
+	}
+	return toUserDetails(username, userBin), nil
+}
+
+// fetchUser returns the bin of the user, nil if the user does not exist.
+func (aero *AerospikeRepository) fetchUser(username string) (map[string]interface{}, error) {
+	record, err := aero.client.Get(nil, aero.baseKey, username)
+	if err != nil {
+		return nil, err
+	}
+	// Bin(user1: {username: user1, password: sha256, roles: [admin], claims: {tenant: t1}})
+	userBin, _ := record.Bins[username].(map[string]interface{})
+	return userBin, nil
+}
+
+// AuthenticateClient validates the client id and secret before issuing a JWT.
+func (aero *AerospikeRepository) AuthenticateClient(clientID string, clientSecret string) *ClientDetails {
+	record, err := aero.client.Get(nil, aero.clientKey, clientID)
+	if err != nil {
+		slog.Error("Failed to fetch record", "key", aero.clientKey, "err", err)
+		return nil
+	}
 
-	return &UserDetails{
-		UserName: username,
-		UserRole: userBin["role"].(UserRole),
+	// Bin(client1: {secret: bcrypt, role: service, scopes: [read, write]})
+	clientBin, ok := record.Bins[clientID].(map[string]interface{})
+	if !ok {
+		slog.Debug("Client not found", "client", clientID)
+		return nil
+	}
+	hashed, ok := clientBin["secret"].(string)
+	if !ok || !pwdMatch(aero.hasher, hashed, clientSecret) {
+		slog.Debug("Failed to authenticate client", "client", clientID)
+		return nil
+	}
+
+	role, _ := clientBin["role"].(string)
+	return &ClientDetails{
+		ClientID:   clientID,
+		ClientRole: UserRole(role),
+		Scopes:     toStringSlice(clientBin["scopes"]),
 	}
 }
 
+// UpdatePassword replaces the password hash of the user.
+func (aero *AerospikeRepository) UpdatePassword(username string, hashed string) error {
+	_, err := aero.client.Operate(nil, aero.baseKey,
+		as.MapPutOp(as.DefaultMapPolicy(), username, "password", hashed))
+	return err
+}
+
 // AuthorizeRequest checks if the role has permissions to access the endpoint.
-func (aero *AerospikeRepository) AuthorizeRequest(userRole UserRole, request RequestDetails) bool {
+func (aero *AerospikeRepository) AuthorizeRequest(userRole UserRole, request RequestDetails) Decision {
 	record, err := aero.client.Get(nil, aero.authKey, string(userRole))
 	if err != nil {
 		slog.Error("Failed to fetch record", "key", aero.authKey, "err", err)
-		return false
+		return Decision{Reason: ReasonRepositoryError, Role: userRole}
+	}
+	// Bin(admin: [{method: GET, uri: /health}, {method: [GET, POST], uri: /api/*/items, match: glob}])
+	permissions, compileErr := aero.permissions.get(userRole, record.Bins[string(userRole)])
+	if compileErr != nil {
+		slog.Error("Invalid permissions", "role", userRole, "err", compileErr)
+		return Decision{Reason: ReasonRepositoryError, Role: userRole}
 	}
-	// Bin(admin: [{method: GET, uri: /health}])
-	scopes := record.Bins[string(userRole)].([]map[string]string)
 
-	return isAuthorizedRequest(scopes, request)
+	return authorizeRequest(userRole, permissions, request)
 }
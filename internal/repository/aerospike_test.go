@@ -12,6 +12,7 @@ func Test_getAerospikeConfig(t *testing.T) {
 	os.Setenv(envAerospikeSet, "set1")
 	os.Setenv(envAerospikeBasicKey, "basic1")
 	os.Setenv(envAerospikeAuthKey, "authorization1")
+	os.Setenv(envAerospikeClientKey, "clients1")
 
 	config := getAerospikeConfig()
 	if config.hostname != "127.0.0.1" {
@@ -32,4 +33,7 @@ func Test_getAerospikeConfig(t *testing.T) {
 	if config.authorizationKey != "authorization1" {
 		t.Fail()
 	}
+	if config.clientKey != "clients1" {
+		t.Fail()
+	}
 }
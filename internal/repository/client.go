@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ClientDetails represents OAuth 2.0 client details.
+type ClientDetails struct {
+	ClientID string
+	// ClientRole is the role used to authorize the client requests.
+	ClientRole UserRole
+	// Scopes is the list of scopes the client is allowed to request.
+	Scopes []string
+}
+
+// A ClientRegistry provides authentication of the OAuth 2.0 clients
+// using the client credentials grant.
+type ClientRegistry interface {
+
+	// AuthenticateClient validates the client id and secret before issuing a JWT.
+	AuthenticateClient(clientID string, clientSecret string) *ClientDetails
+}
+
+// GrantScopes returns the requested scopes if all of them are allowed for the client.
+// If no scopes are requested, all the allowed scopes are granted.
+func (c *ClientDetails) GrantScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return c.Scopes, nil
+	}
+	for _, scope := range requested {
+		if !slices.Contains(c.Scopes, scope) {
+			return nil, fmt.Errorf("scope is not allowed: %s", scope)
+		}
+	}
+	return requested, nil
+}
+
+// ParseScopes parses the space-delimited scope parameter value.
+func ParseScopes(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// toStringSlice converts a list of values fetched from a storage backend.
+func toStringSlice(value any) []string {
+	values, _ := value.([]any)
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/reugn/auth-server/internal/util/hash"
+)
+
+func TestLocal_AuthenticateClient(t *testing.T) {
+	os.Setenv(EnvLocalConfigPath, DefaultLocalConfigPath)
+	repo, err := NewLocal(hash.NewDefaultHasher())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.AuthenticateClient("service", "invalid") != nil {
+		t.Fatal("client with invalid secret was authenticated")
+	}
+	if repo.AuthenticateClient("unknown", "secret") != nil {
+		t.Fatal("unknown client was authenticated")
+	}
+	client := repo.AuthenticateClient("service", "secret")
+	if client == nil {
+		t.Fatal("client authentication failed")
+	}
+	if client.ClientID != "service" || client.ClientRole != "admin" {
+		t.Fatal("client details mismatch")
+	}
+}
+
+func TestClientDetails_GrantScopes(t *testing.T) {
+	client := &ClientDetails{
+		ClientID: "service",
+		Scopes:   []string{"read", "write"},
+	}
+	tests := []struct {
+		name    string
+		scope   string
+		granted []string
+		valid   bool
+	}{
+		{"all-allowed", "", []string{"read", "write"}, true},
+		{"subset", "read", []string{"read"}, true},
+		{"all-requested", " write  read ", []string{"write", "read"}, true},
+		{"not-allowed", "read admin", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			granted, err := client.GrantScopes(ParseScopes(tt.scope))
+			if (err == nil) != tt.valid {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(granted, tt.granted) {
+				t.Fatalf("unexpected scopes: %v", granted)
+			}
+		})
+	}
+}
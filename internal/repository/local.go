@@ -1,36 +1,117 @@
 package repository
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/reugn/auth-server/internal/util/env"
+	"github.com/reugn/auth-server/internal/util/hash"
 	"gopkg.in/yaml.v3"
 )
 
 const (
 	EnvLocalConfigPath     = "AUTH_SERVER_LOCAL_CONFIG_PATH"
 	DefaultLocalConfigPath = "../../config/local_repository_config.yml"
+	// EnvLocalAllowPlaintext allows plaintext passwords and secrets, intended for development only.
+	EnvLocalAllowPlaintext = "AUTH_SERVER_LOCAL_ALLOW_PLAINTEXT"
 )
 
+// fallbackDummyHash is a bcrypt hash of a random password, verified for the unknown
+// users and clients if the hasher fails to produce the dummy hash.
+const fallbackDummyHash = "$2a$10$e9m38sxjEyaCJIMjdee2auyzKvZDyZ.5nqb15Rp5o12Kt0X8CDBRO"
+
 // AuthDetails contains authentication details for the user.
 type AuthDetails struct {
-	Password string   `yaml:"password"`
-	Role     UserRole `yaml:"role"`
+	// Password is the password hash, see hash.IsPasswordHash for the supported formats.
+	Password string `yaml:"password"`
+	// Role and Roles are merged, enabling both the single and multiple roles notation.
+	Role   UserRole       `yaml:"role"`
+	Roles  []UserRole     `yaml:"roles"`
+	Claims map[string]any `yaml:"claims"`
+}
+
+// UserRoles returns the list of unique user roles.
+func (d *AuthDetails) UserRoles() []UserRole {
+	userRoles := make([]UserRole, 0, len(d.Roles)+1)
+	if d.Role != "" {
+		userRoles = append(userRoles, d.Role)
+	}
+	for _, role := range d.Roles {
+		if !slices.Contains(userRoles, role) {
+			userRoles = append(userRoles, role)
+		}
+	}
+	return userRoles
+}
+
+// ClientAuthDetails contains authentication details for the OAuth 2.0 client.
+type ClientAuthDetails struct {
+	// Secret is the client secret hash, in the same formats as the user password.
+	Secret string   `yaml:"secret"`
+	Role   UserRole `yaml:"role"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// Role contains the role permissions and the parent roles to inherit the permissions from.
+// It can be specified either as a list of permissions or as a map with the inherits and
+// permissions properties.
+type Role struct {
+	Inherits    []UserRole   `yaml:"inherits,omitempty"`
+	Permissions []Permission `yaml:"permissions,omitempty"`
+}
+
+// roleFields is used to unmarshal the fields of Role without recursion.
+type roleFields Role
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (r *Role) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		r.Inherits = nil
+		return value.Decode(&r.Permissions)
+	}
+	var fields roleFields
+	if err := value.Decode(&fields); err != nil {
+		return err
+	}
+	*r = Role(fields)
+	return nil
 }
 
 // Local implements the Repository interface by loading authentication details from
 // a local configuration file.
 type Local struct {
-	Users map[string]AuthDetails        `yaml:"users"`
-	Roles map[UserRole][]RequestDetails `yaml:"roles"`
+	Users   map[string]AuthDetails       `yaml:"users"`
+	Clients map[string]ClientAuthDetails `yaml:"clients"`
+	Roles   map[UserRole]Role            `yaml:"roles"`
+
+	// permissions contains the role permissions including the inherited ones.
+	permissions map[UserRole][]Permission
+	// hasher verifies the password and secret hashes.
+	hasher hash.PasswordHasher
+	// allowPlaintext allows the plaintext passwords and secrets.
+	allowPlaintext bool
+	// dummyHash is verified for the unknown users and clients, so that the response
+	// time does not reveal whether they exist. It is produced once by the hasher to
+	// match the verification cost of the stored hashes.
+	dummyHash     string
+	dummyHashOnce sync.Once
 }
 
-var _ Repository = (*Local)(nil)
+var (
+	_ Repository     = (*Local)(nil)
+	_ ClientRegistry = (*Local)(nil)
+)
 
 // NewLocal returns a new Local repository using an environment variable to
-// read a custom path to the configuration file.
-func NewLocal() (*Local, error) {
+// read a custom path to the configuration file. The repository is read-only,
+// the outdated password hashes are not upgraded.
+func NewLocal(hasher hash.PasswordHasher) (*Local, error) {
 	configPath := DefaultLocalConfigPath
 	env.ReadString(&configPath, EnvLocalConfigPath)
 
@@ -39,44 +120,224 @@ func NewLocal() (*Local, error) {
 		return nil, err
 	}
 
-	localRepository := &Local{}
+	localRepository := &Local{hasher: hasher}
 	if err = yaml.Unmarshal(data, localRepository); err != nil {
 		return nil, err
 	}
+	env.ReadBool(&localRepository.allowPlaintext, EnvLocalAllowPlaintext)
+	if err = localRepository.validateCredentials(); err != nil {
+		return nil, err
+	}
+	if err = localRepository.resolveRoles(); err != nil {
+		return nil, err
+	}
 
 	return localRepository, nil
 }
 
-// AuthenticateBasic validates the basic username and password before issuing a JWT.
-func (local *Local) AuthenticateBasic(username string, password string) *UserDetails {
-	if authDetails, ok := local.Users[username]; ok {
-		if authDetails.Password == password {
-			return &UserDetails{
-				UserName: username,
-				UserRole: authDetails.Role,
-			}
+// validateCredentials ensures the user passwords and client secrets are hashed,
+// unless the plaintext credentials are explicitly allowed for development.
+func (local *Local) validateCredentials() error {
+	var plaintext []string
+	for username, authDetails := range local.Users {
+		if !hash.IsPasswordHash(authDetails.Password) {
+			plaintext = append(plaintext, "user "+username)
 		}
 	}
+	for clientID, authDetails := range local.Clients {
+		if !hash.IsPasswordHash(authDetails.Secret) {
+			plaintext = append(plaintext, "client "+clientID)
+		}
+	}
+	if len(plaintext) == 0 {
+		return nil
+	}
+	slices.Sort(plaintext)
+	if !local.allowPlaintext {
+		return fmt.Errorf("plaintext credentials of %s: hash them using the migrate command or set %s",
+			strings.Join(plaintext, ", "), EnvLocalAllowPlaintext)
+	}
+	slog.Warn("Plaintext credentials are allowed, do not use in production", "entries", plaintext)
 	return nil
 }
 
+// credentialsMatch reports whether the plain credential matches the stored one.
+func (local *Local) credentialsMatch(stored string, plain string) bool {
+	if hash.IsPasswordHash(stored) {
+		return pwdMatch(local.hasher, stored, plain)
+	}
+	return local.allowPlaintext && subtle.ConstantTimeCompare([]byte(stored), []byte(plain)) == 1
+}
+
+// verifyDummy verifies the credential against the dummy hash, spending the same time
+// as the verification of an existing user or client.
+func (local *Local) verifyDummy(plain string) {
+	local.dummyHashOnce.Do(func() {
+		dummyHash, err := local.hasher.Hash("dummy-password")
+		if err != nil {
+			slog.Error("Failed to produce the dummy hash", "err", err)
+			dummyHash = fallbackDummyHash
+		}
+		local.dummyHash = dummyHash
+	})
+	pwdMatch(local.hasher, local.dummyHash, plain)
+}
+
+// resolveRoles compiles the role permissions and flattens the role hierarchy,
+// so that each role contains the permissions of all its ancestors.
+// It returns an error if a parent role is unknown or the inheritance is cyclic.
+func (local *Local) resolveRoles() error {
+	for name, role := range local.Roles {
+		if err := compilePermissions(role.Permissions); err != nil {
+			return fmt.Errorf("role %s: %w", name, err)
+		}
+	}
+
+	local.permissions = make(map[UserRole][]Permission, len(local.Roles))
+	for name := range local.Roles {
+		permissions, err := local.flattenRole(name, nil, make(map[UserRole]bool))
+		if err != nil {
+			return err
+		}
+		local.permissions[name] = permissions
+	}
+	return nil
+}
+
+// flattenRole returns the permissions of the role and its ancestors.
+// The path contains the roles being resolved to detect cycles, and the
+// resolved set prevents duplicating the permissions of the shared ancestors.
+func (local *Local) flattenRole(name UserRole, path []UserRole,
+	resolved map[UserRole]bool) ([]Permission, error) {
+	if slices.Contains(path, name) {
+		return nil, fmt.Errorf("cyclic role inheritance: %v", append(path, name))
+	}
+	role, ok := local.Roles[name]
+	if !ok {
+		return nil, fmt.Errorf("role %s inherits unknown role %s", path[len(path)-1], name)
+	}
+	if resolved[name] {
+		return nil, nil
+	}
+	resolved[name] = true
+
+	permissions := slices.Clone(role.Permissions)
+	for _, parent := range role.Inherits {
+		inherited, err := local.flattenRole(parent, append(path, name), resolved)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, inherited...)
+	}
+	return permissions, nil
+}
+
+// AuthenticateBasic validates the basic username and password before issuing a JWT.
+func (local *Local) AuthenticateBasic(username string, password string) *UserDetails {
+	authDetails, ok := local.Users[username]
+	if !ok {
+		local.verifyDummy(password)
+		return nil
+	}
+	if !local.credentialsMatch(authDetails.Password, password) {
+		return nil
+	}
+	return &UserDetails{
+		UserName:  username,
+		UserRoles: authDetails.UserRoles(),
+		Claims:    authDetails.Claims,
+	}
+}
+
+// LookupUser returns the details of the user without verifying the password.
+func (local *Local) LookupUser(username string) (*UserDetails, error) {
+	authDetails, ok := local.Users[username]
+	if !ok {
+		return nil, nil
+	}
+	return &UserDetails{
+		UserName:  username,
+		UserRoles: authDetails.UserRoles(),
+		Claims:    authDetails.Claims,
+	}, nil
+}
+
+// AuthenticateClient validates the client id and secret before issuing a JWT.
+func (local *Local) AuthenticateClient(clientID string, clientSecret string) *ClientDetails {
+	authDetails, ok := local.Clients[clientID]
+	if !ok {
+		local.verifyDummy(clientSecret)
+		return nil
+	}
+	if !local.credentialsMatch(authDetails.Secret, clientSecret) {
+		return nil
+	}
+	return &ClientDetails{
+		ClientID:   clientID,
+		ClientRole: authDetails.Role,
+		Scopes:     authDetails.Scopes,
+	}
+}
+
 // AuthorizeRequest checks if the role has permissions to access the endpoint.
-func (local *Local) AuthorizeRequest(userRole UserRole, requestDetails RequestDetails) bool {
-	if permissions, ok := local.Roles[userRole]; ok {
-		if containsRequestDetails(permissions, requestDetails) {
-			slog.Debug("Request authorized", "request", requestDetails)
-			return true
+func (local *Local) AuthorizeRequest(userRole UserRole, requestDetails RequestDetails) Decision {
+	return authorizeRequest(userRole, local.permissions[userRole], requestDetails)
+}
+
+// MigrateLocalConfig hashes the plaintext user passwords and client secrets of the
+// local repository configuration using the hasher, preserving the rest of the
+// document. It returns the migrated configuration and the number of hashed entries.
+func MigrateLocalConfig(data []byte, hasher hash.PasswordHasher) ([]byte, int, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, 0, err
+	}
+	if len(document.Content) == 0 {
+		return data, 0, nil
+	}
+
+	migrated := 0
+	for section, field := range map[string]string{"users": "password", "clients": "secret"} {
+		entries := mappingValue(document.Content[0], section)
+		if entries == nil || entries.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 1; i < len(entries.Content); i += 2 {
+			value := mappingValue(entries.Content[i], field)
+			if value == nil || value.Kind != yaml.ScalarNode || hash.IsPasswordHash(value.Value) {
+				continue
+			}
+			hashed, err := hasher.Hash(value.Value)
+			if err != nil {
+				return nil, 0, err
+			}
+			value.SetString(hashed)
+			value.Style = yaml.DoubleQuotedStyle
+			migrated++
 		}
 	}
-	slog.Debug("Authorization failed for the request", "request", requestDetails)
-	return false
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, 0, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), migrated, nil
 }
 
-func containsRequestDetails(details []RequestDetails, requestDetails RequestDetails) bool {
-	for _, detail := range details {
-		if detail == requestDetails {
-			return true
+// mappingValue returns the value node of the key in the mapping node.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
 		}
 	}
-	return false
+	return nil
 }
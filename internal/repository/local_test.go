@@ -0,0 +1,282 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/reugn/auth-server/internal/util/hash"
+	"gopkg.in/yaml.v3"
+)
+
+func newTestLocal(t *testing.T, config string) (*Local, error) {
+	t.Helper()
+	local := &Local{hasher: hash.NewDefaultHasher()}
+	if err := yaml.Unmarshal([]byte(config), local); err != nil {
+		t.Fatal(err)
+	}
+	return local, local.resolveRoles()
+}
+
+func TestLocal_RoleInheritance(t *testing.T) {
+	local, err := newTestLocal(t, `
+roles:
+  viewer:
+    - method: GET
+      uri: /articles/**
+  editor:
+    inherits: [viewer]
+    permissions:
+      - method: PUT
+        uri: /articles/{id}
+  reviewer:
+    inherits: [viewer]
+  admin:
+    inherits: [editor, reviewer]
+    permissions:
+      - method: DELETE
+        uri: /articles/archived/**
+        effect: deny
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		role    UserRole
+		request RequestDetails
+		allowed bool
+	}{
+		{"viewer", RequestDetails{Method: "GET", URI: "/articles/1"}, true},
+		{"viewer", RequestDetails{Method: "PUT", URI: "/articles/1"}, false},
+		{"editor", RequestDetails{Method: "GET", URI: "/articles/1"}, true},
+		{"editor", RequestDetails{Method: "PUT", URI: "/articles/1"}, true},
+		{"admin", RequestDetails{Method: "GET", URI: "/articles/1"}, true},
+		{"admin", RequestDetails{Method: "PUT", URI: "/articles/1"}, true},
+		{"admin", RequestDetails{Method: "DELETE", URI: "/articles/archived/1"}, false},
+		{"unknown", RequestDetails{Method: "GET", URI: "/articles/1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.role)+" "+tt.request.String(), func(t *testing.T) {
+			if local.AuthorizeRequest(tt.role, tt.request).Allowed != tt.allowed {
+				t.Fatal("authorization result mismatch")
+			}
+		})
+	}
+
+	// the permissions of the shared ancestors are not duplicated
+	if len(local.permissions["admin"]) != 3 {
+		t.Fatalf("unexpected admin permissions: %v", local.permissions["admin"])
+	}
+}
+
+func TestLocal_RoleInheritanceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"self", `
+roles:
+  admin:
+    inherits: [admin]
+`, "cyclic role inheritance"},
+		{"cycle", `
+roles:
+  viewer:
+    inherits: [admin]
+  editor:
+    inherits: [viewer]
+  admin:
+    inherits: [editor]
+`, "cyclic role inheritance"},
+		{"unknown", `
+roles:
+  admin:
+    inherits: [root]
+`, "unknown role root"},
+		{"invalid-permission", `
+roles:
+  admin:
+    permissions:
+      - uri: /dashboard
+`, "method is not specified"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestLocal(t, tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLocal_Credentials(t *testing.T) {
+	password, err := newTestHasher(t, hash.Argon2id).Hash("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := newTestHasher(t, hash.Scrypt).Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := newTestLocal(t, `
+users:
+  admin:
+    password: "`+password+`"
+    role: admin
+clients:
+  service:
+    secret: "`+secret+`"
+    role: admin
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = local.validateCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if local.AuthenticateBasic("admin", "1234") == nil {
+		t.Fatal("failed to authenticate the user")
+	}
+	if local.AuthenticateBasic("admin", password) != nil {
+		t.Fatal("authenticated the user using the hash")
+	}
+	if local.AuthenticateClient("service", "secret") == nil {
+		t.Fatal("failed to authenticate the client")
+	}
+	if local.AuthenticateClient("service", "1234") != nil {
+		t.Fatal("authenticated the client using a wrong secret")
+	}
+	if userDetails, _ := local.LookupUser("admin"); userDetails == nil || userDetails.UserRoles[0] != "admin" {
+		t.Fatalf("unexpected user details: %+v", userDetails)
+	}
+	if userDetails, _ := local.LookupUser("unknown"); userDetails != nil {
+		t.Fatal("found an unknown user")
+	}
+}
+
+// countingHasher counts the password verifications.
+type countingHasher struct {
+	hash.PasswordHasher
+	verified int
+}
+
+func (h *countingHasher) Verify(encoded string, password string) (bool, error) {
+	h.verified++
+	return h.PasswordHasher.Verify(encoded, password)
+}
+
+func TestLocal_UnknownCredentials(t *testing.T) {
+	local, err := newTestLocal(t, `
+users:
+  admin:
+    # bcrypt hash of "1234"
+    password: "$2a$10$MJRrWom6waW/rpqW6tJGneDqt8X5OBFgRp2qmqqTEVOa3JJODO8pG"
+    role: admin
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := &countingHasher{PasswordHasher: newTestHasher(t, hash.Argon2id)}
+	local.hasher = hasher
+
+	// the unknown users and clients are verified against the dummy hash
+	if local.AuthenticateBasic("unknown", "1234") != nil {
+		t.Fatal("authenticated an unknown user")
+	}
+	if local.AuthenticateClient("unknown", "secret") != nil {
+		t.Fatal("authenticated an unknown client")
+	}
+	if hasher.verified != 2 {
+		t.Fatalf("unexpected number of verifications: %d", hasher.verified)
+	}
+	if hasher.NeedsRehash(local.dummyHash) {
+		t.Fatalf("dummy hash is not produced by the hasher: %s", local.dummyHash)
+	}
+}
+
+func TestLocal_PlaintextCredentials(t *testing.T) {
+	local, err := newTestLocal(t, `
+users:
+  admin:
+    password: 1234
+    role: admin
+clients:
+  service:
+    secret: secret
+    role: admin
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.validateCredentials()
+	if err == nil || !strings.Contains(err.Error(), "client service, user admin") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if local.AuthenticateBasic("admin", "1234") != nil {
+		t.Fatal("authenticated the user using a plaintext password")
+	}
+
+	local.allowPlaintext = true
+	if err = local.validateCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if local.AuthenticateBasic("admin", "1234") == nil {
+		t.Fatal("failed to authenticate the user using a plaintext password")
+	}
+	if local.AuthenticateClient("service", "secret") == nil {
+		t.Fatal("failed to authenticate the client using a plaintext secret")
+	}
+}
+
+func TestMigrateLocalConfig(t *testing.T) {
+	config := `
+users:
+  admin:
+    # the administrator
+    password: 1234
+    role: admin
+  viewer:
+    password: "$2a$10$MJRrWom6waW/rpqW6tJGneDqt8X5OBFgRp2qmqqTEVOa3JJODO8pG"
+clients:
+  service:
+    secret: secret
+roles:
+  admin:
+    - method: "*"
+      uri: "/"
+      match: prefix
+`
+	migrated, count, err := MigrateLocalConfig([]byte(config), newTestHasher(t, hash.Bcrypt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("unexpected number of migrated credentials: %d", count)
+	}
+	if !strings.Contains(string(migrated), "# the administrator") {
+		t.Fatal("comment was not preserved")
+	}
+
+	local, err := newTestLocal(t, string(migrated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = local.validateCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if local.AuthenticateBasic("admin", "1234") == nil || local.AuthenticateBasic("viewer", "1234") == nil {
+		t.Fatal("failed to authenticate the migrated users")
+	}
+	if local.AuthenticateClient("service", "secret") == nil {
+		t.Fatal("failed to authenticate the migrated client")
+	}
+	if len(local.Roles["admin"].Permissions) != 1 {
+		t.Fatal("roles were not preserved")
+	}
+
+	if _, count, err = MigrateLocalConfig(migrated, newTestHasher(t, hash.Bcrypt)); err != nil || count != 0 {
+		t.Fatalf("unexpected repeated migration: %d, %v", count, err)
+	}
+}
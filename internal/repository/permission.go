@@ -0,0 +1,428 @@
+package repository
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/reugn/auth-server/internal/policy"
+	"gopkg.in/yaml.v3"
+)
+
+// MatchType represents the strategy of matching the request URI against
+// the permission URI.
+type MatchType string
+
+const (
+	// MatchExact matches the request path equal to the permission URI.
+	MatchExact MatchType = "exact"
+	// MatchPrefix matches the request path starting with the permission URI.
+	MatchPrefix MatchType = "prefix"
+	// MatchGlob matches the request path using a glob pattern, where * matches
+	// a single path segment, ** matches any number of segments and {name}
+	// matches a single non-empty path parameter, e.g. /users/{id}/items/*.
+	MatchGlob MatchType = "glob"
+	// MatchRegex matches the entire request path using a regular expression.
+	MatchRegex MatchType = "regex"
+)
+
+// Effect represents the effect of a permission on the matching requests.
+type Effect string
+
+const (
+	// EffectAllow grants access to the matching requests.
+	EffectAllow Effect = "allow"
+	// EffectDeny denies access to the matching requests, overriding the allow rules.
+	EffectDeny Effect = "deny"
+)
+
+// anyValue matches any request method or URI.
+const anyValue = "*"
+
+// Methods is a list of HTTP methods, where * matches any method.
+// It can be specified either as a list or as a comma-separated string.
+type Methods []string
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (m *Methods) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*m = parseMethods(value.Value)
+		return nil
+	}
+	var methods []string
+	if err := value.Decode(&methods); err != nil {
+		return err
+	}
+	*m = methods
+	return nil
+}
+
+// matches reports whether the list contains the request method.
+func (m Methods) matches(method string) bool {
+	for _, allowed := range m {
+		if allowed == anyValue || strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseMethods(methods string) Methods {
+	result := make(Methods, 0)
+	for _, method := range strings.Split(methods, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			result = append(result, method)
+		}
+	}
+	return result
+}
+
+// Permission represents a rule granting or denying access to the matching requests.
+type Permission struct {
+	Method Methods `yaml:"method" json:"method"`
+	URI    string  `yaml:"uri" json:"uri"`
+	// Match is the URI matching strategy. If not specified, it is glob for
+	// the URIs containing a wildcard or a path parameter, and exact otherwise.
+	Match MatchType `yaml:"match,omitempty" json:"match,omitempty"`
+	// Effect is the effect of the permission, allow if not specified.
+	Effect Effect `yaml:"effect,omitempty" json:"effect,omitempty"`
+	// Condition is an optional expression the request must satisfy for the
+	// permission to apply, e.g. claims.tenant == request.headers["x-tenant"].
+	Condition string `yaml:"condition,omitempty" json:"condition,omitempty"`
+
+	pattern   *regexp.Regexp
+	literal   int
+	condition *policy.Expression
+}
+
+// String implements the fmt.Stringer interface.
+func (p *Permission) String() string {
+	permission := fmt.Sprintf("%s %s %s", p.Effect, strings.Join(p.Method, ","), p.URI)
+	if p.Condition != "" {
+		permission += " if " + p.Condition
+	}
+	return permission
+}
+
+// Compile validates the permission and prepares it for matching.
+// It must be called before using the permission.
+func (p *Permission) Compile() error {
+	if len(p.Method) == 0 {
+		return fmt.Errorf("permission method is not specified: %s", p)
+	}
+	if p.URI == "" {
+		return fmt.Errorf("permission uri is not specified: %s", p)
+	}
+	switch p.Effect {
+	case "":
+		p.Effect = EffectAllow
+	case EffectAllow, EffectDeny:
+	default:
+		return fmt.Errorf("unsupported permission effect: %s", p.Effect)
+	}
+	if p.Match == "" {
+		p.Match = defaultMatch(p.URI, false)
+	}
+
+	var err error
+	p.literal = len(p.URI)
+	switch p.Match {
+	case MatchExact, MatchPrefix:
+	case MatchGlob:
+		p.pattern, p.literal, err = compileGlob(p.URI)
+	case MatchRegex:
+		p.pattern, err = regexp.Compile("^(?:" + p.URI + ")$")
+	default:
+		return fmt.Errorf("unsupported permission match type: %s", p.Match)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid permission uri %s: %w", p.URI, err)
+	}
+	if p.Condition != "" {
+		if p.condition, err = policy.Parse(p.Condition); err != nil {
+			return fmt.Errorf("invalid permission condition: %w", err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the permission applies to the request.
+// The request URI is matched without the query string. If the condition
+// fails to evaluate, the deny permissions apply and the allow permissions don't.
+func (p *Permission) Matches(request RequestDetails) bool {
+	matches, _ := p.match(request)
+	return matches
+}
+
+// match reports whether the permission applies to the request along with
+// the condition evaluation error.
+func (p *Permission) match(request RequestDetails) (bool, error) {
+	if !p.Method.matches(request.Method) || !p.matchesURI(request.URI) {
+		return false, nil
+	}
+	if p.condition == nil {
+		return true, nil
+	}
+	satisfied, err := p.condition.Evaluate(request.input())
+	if err != nil {
+		slog.Warn("Failed to evaluate permission condition", "permission", p, "err", err)
+		return p.Effect == EffectDeny, err
+	}
+	return satisfied, nil
+}
+
+func (p *Permission) matchesURI(uri string) bool {
+	if p.URI == anyValue {
+		return true
+	}
+	path, _, _ := strings.Cut(uri, "?")
+	switch p.Match {
+	case MatchExact:
+		return path == p.URI
+	case MatchPrefix:
+		return strings.HasPrefix(path, p.URI)
+	case MatchGlob, MatchRegex:
+		return p.pattern != nil && p.pattern.MatchString(path)
+	default:
+		return false
+	}
+}
+
+// specificity returns the rank of the permission used to select the most specific
+// matching rule. The URI match type is compared first, exact being the most specific,
+// then the length of the literal part of the URI, then the method, then the condition.
+func (p *Permission) specificity() []int {
+	var matchRank int
+	switch {
+	case p.URI == anyValue:
+		matchRank = 0
+	case p.Match == MatchPrefix:
+		matchRank = 1
+	case p.Match == MatchExact:
+		matchRank = 3
+	default:
+		matchRank = 2
+	}
+	methodRank := 1
+	if slices.Contains(p.Method, anyValue) {
+		methodRank = 0
+	}
+	conditionRank := 0
+	if p.condition != nil {
+		conditionRank = 1
+	}
+	return []int{matchRank, p.literal, methodRank, conditionRank}
+}
+
+// compileGlob translates the glob pattern to a regular expression.
+// It also returns the number of the literal characters in the pattern.
+func compileGlob(glob string) (*regexp.Regexp, int, error) {
+	var expr strings.Builder
+	var literal int
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expr.WriteString("[^/]*")
+		case glob[i] == '{':
+			end := strings.IndexByte(glob[i:], '}')
+			if end < 2 {
+				return nil, 0, fmt.Errorf("invalid path parameter at %d", i)
+			}
+			expr.WriteString("[^/]+")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			literal++
+		}
+	}
+	expr.WriteString("$")
+	pattern, err := regexp.Compile(expr.String())
+	return pattern, literal, err
+}
+
+// compilePermissions compiles the permissions in place.
+func compilePermissions(permissions []Permission) error {
+	for i := range permissions {
+		if err := permissions[i].Compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorizeRequest evaluates the role permissions against the request.
+// A matching deny rule wins over the allow rules, and the most specific
+// matching rule of the effect is selected as the decision rule.
+// The request is denied if no rule matches.
+func authorizeRequest(userRole UserRole, permissions []Permission, request RequestDetails) Decision {
+	var allow, deny *Permission
+	// the permissions whose conditions failed to evaluate
+	var failed []*Permission
+	for i := range permissions {
+		permission := &permissions[i]
+		matches, err := permission.match(request)
+		if err != nil {
+			failed = append(failed, permission)
+		}
+		if !matches {
+			continue
+		}
+		if permission.Effect == EffectDeny {
+			deny = moreSpecific(deny, permission)
+		} else {
+			allow = moreSpecific(allow, permission)
+		}
+	}
+
+	decision := Decision{Reason: ReasonNoMatchingRule, Role: userRole}
+	switch {
+	case deny != nil:
+		decision.Reason = ReasonExplicitDeny
+		if slices.Contains(failed, deny) {
+			decision.Reason = ReasonConditionError
+		}
+		decision.Rule = deny
+	case allow != nil:
+		decision.Allowed = true
+		decision.Reason = ReasonRuleMatched
+		decision.Rule = allow
+	case len(failed) > 0:
+		decision.Reason = ReasonConditionError
+	}
+	slog.Debug("Authorization decision", "request", request, "decision", decision)
+	return decision
+}
+
+// moreSpecific returns the more specific of the permissions.
+func moreSpecific(current *Permission, candidate *Permission) *Permission {
+	if current == nil || slices.Compare(candidate.specificity(), current.specificity()) > 0 {
+		return candidate
+	}
+	return current
+}
+
+// permissionCache caches the permissions compiled from the rule sets fetched
+// from a storage backend, sparing the compilation on every authorization.
+// The rule set of a role is recompiled once its contents change.
+type permissionCache struct {
+	mu      sync.RWMutex
+	entries map[UserRole]*compiledPermissions
+}
+
+// compiledPermissions contains the permissions compiled from a rule set, or
+// the error of the invalid rule set.
+type compiledPermissions struct {
+	fingerprint string
+	permissions []Permission
+	err         error
+}
+
+func newPermissionCache() *permissionCache {
+	return &permissionCache{
+		entries: make(map[UserRole]*compiledPermissions),
+	}
+}
+
+// get returns the permissions of the role converted from the fetched rule set.
+func (c *permissionCache) get(userRole UserRole, value any) ([]Permission, error) {
+	// the map keys are printed sorted, making the fingerprint deterministic
+	fingerprint := fmt.Sprint(value)
+	c.mu.RLock()
+	entry, ok := c.entries[userRole]
+	c.mu.RUnlock()
+	if ok && entry.fingerprint == fingerprint {
+		return entry.permissions, entry.err
+	}
+
+	permissions, err := toPermissions(value)
+	c.mu.Lock()
+	c.entries[userRole] = &compiledPermissions{
+		fingerprint: fingerprint,
+		permissions: permissions,
+		err:         err,
+	}
+	c.mu.Unlock()
+	return permissions, err
+}
+
+// defaultMatch returns the match type of the permission URI if not specified:
+// glob for the URIs containing a wildcard or a path parameter, and exact otherwise.
+// The other URIs of the backend rules are matched by prefix for compatibility.
+func defaultMatch(uri string, backend bool) MatchType {
+	switch {
+	case strings.ContainsAny(uri, "*{"):
+		return MatchGlob
+	case backend:
+		return MatchPrefix
+	default:
+		return MatchExact
+	}
+}
+
+// toPermissions converts the list of permissions fetched from a storage backend.
+// It fails if any of the permissions is invalid, so that a malformed deny rule
+// does not leave the allow rules of the role in effect. For compatibility with the rules predating
+// the match property, the URIs without a wildcard or a path parameter are matched
+// by prefix if the match is not specified.
+func toPermissions(value any) ([]Permission, error) {
+	var entries []map[string]any
+	switch values := value.(type) {
+	case []map[string]string:
+		for _, entry := range values {
+			converted := make(map[string]any, len(entry))
+			for key, value := range entry {
+				converted[key] = value
+			}
+			entries = append(entries, converted)
+		}
+	case []map[string]any:
+		entries = values
+	case []any:
+		for _, entry := range values {
+			converted := toStringMap(entry)
+			if converted == nil {
+				return nil, fmt.Errorf("invalid permission: %v", entry)
+			}
+			entries = append(entries, converted)
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("invalid permissions type: %T", value)
+	}
+
+	permissions := make([]Permission, 0, len(entries))
+	for _, entry := range entries {
+		permission := Permission{}
+		switch method := entry["method"].(type) {
+		case string:
+			permission.Method = parseMethods(method)
+		default:
+			permission.Method = toStringSlice(method)
+		}
+		permission.URI, _ = entry["uri"].(string)
+		match, _ := entry["match"].(string)
+		permission.Match = MatchType(match)
+		effect, _ := entry["effect"].(string)
+		permission.Effect = Effect(effect)
+		permission.Condition, _ = entry["condition"].(string)
+		if permission.Match == "" {
+			permission.Match = defaultMatch(permission.URI, true)
+			if permission.Match == MatchPrefix {
+				slog.Warn("Permission relies on the deprecated implicit prefix match, set the match explicitly",
+					"permission", &permission)
+			}
+		}
+		if err := permission.Compile(); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, nil
+}
@@ -0,0 +1,370 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPermission_Matches(t *testing.T) {
+	tests := []struct {
+		name       string
+		permission Permission
+		request    RequestDetails
+		matches    bool
+	}{
+		{"exact", Permission{Method: Methods{"GET"}, URI: "/dashboard"},
+			RequestDetails{Method: "GET", URI: "/dashboard"}, true},
+		{"exact-query", Permission{Method: Methods{"GET"}, URI: "/dashboard"},
+			RequestDetails{Method: "GET", URI: "/dashboard?page=1"}, true},
+		{"exact-sub-path", Permission{Method: Methods{"GET"}, URI: "/dashboard"},
+			RequestDetails{Method: "GET", URI: "/dashboard/1"}, false},
+		{"exact-method", Permission{Method: Methods{"GET"}, URI: "/dashboard"},
+			RequestDetails{Method: "POST", URI: "/dashboard"}, false},
+		{"method-case", Permission{Method: Methods{"get"}, URI: "/dashboard"},
+			RequestDetails{Method: "GET", URI: "/dashboard"}, true},
+		{"method-list", Permission{Method: Methods{"GET", "POST"}, URI: "/dashboard"},
+			RequestDetails{Method: "POST", URI: "/dashboard"}, true},
+		{"method-wildcard", Permission{Method: Methods{"*"}, URI: "/dashboard"},
+			RequestDetails{Method: "DELETE", URI: "/dashboard"}, true},
+		{"uri-wildcard", Permission{Method: Methods{"GET"}, URI: "*"},
+			RequestDetails{Method: "GET", URI: "/any/path"}, true},
+		{"prefix", Permission{Method: Methods{"GET"}, URI: "/api/", Match: MatchPrefix},
+			RequestDetails{Method: "GET", URI: "/api/v1/items"}, true},
+		{"prefix-mismatch", Permission{Method: Methods{"GET"}, URI: "/api/", Match: MatchPrefix},
+			RequestDetails{Method: "GET", URI: "/apiv1"}, false},
+		{"glob", Permission{Method: Methods{"GET"}, URI: "/api/*/items"},
+			RequestDetails{Method: "GET", URI: "/api/v1/items"}, true},
+		{"glob-segment", Permission{Method: Methods{"GET"}, URI: "/api/*/items"},
+			RequestDetails{Method: "GET", URI: "/api/v1/v2/items"}, false},
+		{"glob-any-segments", Permission{Method: Methods{"GET"}, URI: "/api/**"},
+			RequestDetails{Method: "GET", URI: "/api/v1/v2/items"}, true},
+		{"glob-literal", Permission{Method: Methods{"GET"}, URI: "/api.v1/*"},
+			RequestDetails{Method: "GET", URI: "/apixv1/items"}, false},
+		{"path-param", Permission{Method: Methods{"GET"}, URI: "/users/{id}"},
+			RequestDetails{Method: "GET", URI: "/users/42"}, true},
+		{"path-param-empty", Permission{Method: Methods{"GET"}, URI: "/users/{id}"},
+			RequestDetails{Method: "GET", URI: "/users/"}, false},
+		{"path-param-nested", Permission{Method: Methods{"GET"}, URI: "/users/{id}/items/*"},
+			RequestDetails{Method: "GET", URI: "/users/42/items/7"}, true},
+		{"path-param-exact", Permission{Method: Methods{"GET"}, URI: "/users/{id}", Match: MatchExact},
+			RequestDetails{Method: "GET", URI: "/users/42"}, false},
+		{"regex", Permission{Method: Methods{"GET"}, URI: `/users/\d+`, Match: MatchRegex},
+			RequestDetails{Method: "GET", URI: "/users/42"}, true},
+		{"regex-anchored", Permission{Method: Methods{"GET"}, URI: `/users/\d+`, Match: MatchRegex},
+			RequestDetails{Method: "GET", URI: "/users/42/items"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.permission.Compile(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.permission.Matches(tt.request) != tt.matches {
+				t.Fatal("match result mismatch")
+			}
+		})
+	}
+}
+
+func TestPermission_Compile(t *testing.T) {
+	tests := []struct {
+		name       string
+		permission Permission
+	}{
+		{"no-method", Permission{URI: "/dashboard"}},
+		{"no-uri", Permission{Method: Methods{"GET"}}},
+		{"invalid-match", Permission{Method: Methods{"GET"}, URI: "/", Match: "fuzzy"}},
+		{"invalid-regex", Permission{Method: Methods{"GET"}, URI: "/(", Match: MatchRegex}},
+		{"invalid-param", Permission{Method: Methods{"GET"}, URI: "/users/{}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.permission.Compile(); err == nil {
+				t.Fatal("expected compile error")
+			}
+		})
+	}
+}
+
+func TestPermission_Unmarshal(t *testing.T) {
+	data := `
+- method: GET, POST
+  uri: /users/{id}
+- method: [PUT, PATCH]
+  uri: /items/
+  match: prefix
+`
+	var permissions []Permission
+	if err := yaml.Unmarshal([]byte(data), &permissions); err != nil {
+		t.Fatal(err)
+	}
+	if err := compilePermissions(permissions); err != nil {
+		t.Fatal(err)
+	}
+	if !authorizeRequest("admin", permissions, RequestDetails{Method: "POST", URI: "/users/1"}).Allowed {
+		t.Fatal("method string list is not supported")
+	}
+	if !authorizeRequest("admin", permissions, RequestDetails{Method: "PATCH", URI: "/items/1"}).Allowed {
+		t.Fatal("method list is not supported")
+	}
+	if authorizeRequest("admin", permissions, RequestDetails{Method: "GET", URI: "/items/1"}).Allowed {
+		t.Fatal("unexpected method authorized")
+	}
+}
+
+func Test_toPermissions(t *testing.T) {
+	// the permissions as decoded by the storage backend clients
+	backends := map[string]any{
+		"string-map": []map[string]string{{"method": "GET", "uri": "/users/{id}"}},
+		"any-map": []any{
+			map[string]any{"method": []any{"PUT", "GET"}, "uri": "/users/", "match": "prefix"},
+		},
+	}
+	for name, value := range backends {
+		t.Run(name, func(t *testing.T) {
+			permissions, err := toPermissions(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(permissions) != 1 {
+				t.Fatalf("unexpected permissions: %v", permissions)
+			}
+			if !authorizeRequest("admin", permissions, RequestDetails{Method: "GET", URI: "/users/1"}).Allowed {
+				t.Fatal("request is not authorized")
+			}
+		})
+	}
+}
+
+func Test_toPermissionsInvalid(t *testing.T) {
+	tests := map[string]any{
+		"deny regex": []any{
+			map[string]any{"method": "*", "uri": "/api/", "match": "prefix"},
+			map[string]any{"method": "DELETE", "uri": "/api/(", "match": "regex", "effect": "deny"},
+		},
+		"deny glob": []map[string]string{
+			{"method": "*", "uri": "/api/", "match": "prefix"},
+			{"method": "DELETE", "uri": "/api/{id", "effect": "deny"},
+		},
+		"effect":  []map[string]string{{"method": "GET", "uri": "/api/", "effect": "block"}},
+		"entry":   []any{"GET /api/"},
+		"type":    "GET /api/",
+		"no uri":  []map[string]string{{"method": "GET"}},
+		"no verb": []map[string]string{{"uri": "/api/"}},
+	}
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			if permissions, err := toPermissions(value); err == nil {
+				t.Fatalf("expected error, got %v", permissions)
+			}
+		})
+	}
+}
+
+func Test_toPermissionsDefaultMatch(t *testing.T) {
+	permissions, _ := toPermissions([]map[string]string{
+		{"method": "GET", "uri": "/api"},
+		{"method": "GET", "uri": "/static/*.css"},
+		{"method": "GET", "uri": "/health", "match": "exact"},
+	})
+	tests := []struct {
+		uri     string
+		allowed bool
+	}{
+		{"/api", true},
+		{"/api/items", true},
+		{"/api/items?page=2", true},
+		{"/static/main.css", true},
+		{"/static/css/main.css", false},
+		{"/health", true},
+		{"/health/live", false},
+	}
+	for _, tt := range tests {
+		decision := authorizeRequest("admin", permissions, RequestDetails{Method: "GET", URI: tt.uri})
+		if decision.Allowed != tt.allowed {
+			t.Fatalf("unexpected decision for %s: %v", tt.uri, decision.Allowed)
+		}
+	}
+}
+
+func TestPermission_LocalAndBackend(t *testing.T) {
+	rules := []map[string]string{
+		{"method": "GET", "uri": "/api", "match": "prefix"},
+		{"method": "GET", "uri": "/health", "match": "exact"},
+		{"method": "GET", "uri": "/static/*.css"},
+		{"method": "GET", "uri": "/users/{id}"},
+		{"method": "GET", "uri": "/docs"},
+	}
+	data, err := yaml.Marshal(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var local []Permission
+	if err = yaml.Unmarshal(data, &local); err != nil {
+		t.Fatal(err)
+	}
+	if err = compilePermissions(local); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := toPermissions(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		uri     string
+		local   bool
+		backend bool
+	}{
+		{"/api/items", true, true},
+		{"/health", true, true},
+		{"/health/live", false, false},
+		{"/static/main.css", true, true},
+		{"/static/css/main.css", false, false},
+		{"/users/42", true, true},
+		{"/users/42/items", false, false},
+		// the backend rules without a wildcard are matched by prefix if not specified
+		{"/docs", true, true},
+		{"/docs/intro", false, true},
+	}
+	for _, tt := range tests {
+		request := RequestDetails{Method: "GET", URI: tt.uri}
+		if decision := authorizeRequest("admin", local, request); decision.Allowed != tt.local {
+			t.Fatalf("unexpected local decision for %s: %v", tt.uri, decision.Allowed)
+		}
+		if decision := authorizeRequest("admin", backend, request); decision.Allowed != tt.backend {
+			t.Fatalf("unexpected backend decision for %s: %v", tt.uri, decision.Allowed)
+		}
+	}
+}
+
+func TestPermissionCache(t *testing.T) {
+	cache := newPermissionCache()
+	rules := []any{map[string]any{"method": "GET", "uri": "/api/{id}"}}
+	permissions, _ := cache.get("admin", rules)
+	if len(permissions) != 1 {
+		t.Fatalf("unexpected permissions: %v", permissions)
+	}
+	// the unchanged rule set is not recompiled
+	cached, _ := cache.get("admin", []any{map[string]any{"uri": "/api/{id}", "method": "GET"}})
+	if &cached[0] != &permissions[0] {
+		t.Fatal("permissions are recompiled")
+	}
+	if other, _ := cache.get("user", rules); &other[0] == &permissions[0] {
+		t.Fatal("roles share the permissions")
+	}
+
+	rules = append(rules, map[string]any{"method": "DELETE", "uri": "/api/{id}", "effect": "deny"})
+	permissions, _ = cache.get("admin", rules)
+	if len(permissions) != 2 {
+		t.Fatalf("changed rule set is not recompiled: %v", permissions)
+	}
+	if authorizeRequest("admin", permissions, RequestDetails{Method: "DELETE", URI: "/api/1"}).Allowed {
+		t.Fatal("request is authorized")
+	}
+
+	// the role with a malformed deny rule loses all its permissions
+	rules[1] = map[string]any{"method": "DELETE", "uri": "/api/(", "match": "regex", "effect": "deny"}
+	if permissions, err := cache.get("admin", rules); err == nil || permissions != nil {
+		t.Fatalf("invalid rule set is compiled: %v", permissions)
+	}
+}
+
+func TestAuthorizeRequest_Precedence(t *testing.T) {
+	permissions := []Permission{
+		{Method: Methods{"*"}, URI: "/api/", Match: MatchPrefix},
+		{Method: Methods{"DELETE"}, URI: "/api/billing", Effect: EffectDeny},
+		{Method: Methods{"*"}, URI: "/api/internal/**", Effect: EffectDeny},
+		{Method: Methods{"GET"}, URI: "/api/internal/status"},
+		{Method: Methods{"GET"}, URI: "/api/{resource}"},
+	}
+	if err := compilePermissions(permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		request RequestDetails
+		allowed bool
+		rule    *Permission
+	}{
+		{"allow-prefix", RequestDetails{Method: "DELETE", URI: "/api/items"}, true, &permissions[0]},
+		{"allow-most-specific", RequestDetails{Method: "GET", URI: "/api/billing"}, true, &permissions[4]},
+		{"deny", RequestDetails{Method: "DELETE", URI: "/api/billing"}, false, &permissions[1]},
+		{"deny-wins", RequestDetails{Method: "GET", URI: "/api/internal/status"}, false, &permissions[2]},
+		{"no-matching-rule", RequestDetails{Method: "GET", URI: "/dashboard"}, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := authorizeRequest("admin", permissions, tt.request)
+			if decision.Allowed != tt.allowed {
+				t.Fatalf("unexpected decision: %s", decision)
+			}
+			if decision.Rule != tt.rule || decision.Role != "admin" {
+				t.Fatalf("unexpected decision rule: %s", decision)
+			}
+		})
+	}
+}
+
+func TestPermission_Condition(t *testing.T) {
+	permissions := []Permission{
+		{Method: Methods{"GET"}, URI: "/reports", Condition: `time.hour >= 8 && time.hour < 20`},
+		{Method: Methods{"GET"}, URI: "/reports", Effect: EffectDeny,
+			Condition: `claims.suspended == true || claims.level > 5`},
+	}
+	if err := compilePermissions(permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		hour    int
+		claims  map[string]any
+		allowed bool
+		reason  Reason
+	}{
+		{"in-hours", 9, map[string]any{}, true, ReasonRuleMatched},
+		{"out-of-hours", 21, map[string]any{}, false, ReasonNoMatchingRule},
+		{"deny-condition", 9, map[string]any{"suspended": true}, false, ReasonExplicitDeny},
+		// a deny condition failing to evaluate denies the request
+		{"deny-evaluation-error", 9, map[string]any{"level": "high"}, false, ReasonConditionError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := RequestDetails{
+				Method: "GET",
+				URI:    "/reports",
+				Claims: tt.claims,
+				Time:   time.Date(2024, 1, 15, tt.hour, 0, 0, 0, time.Local),
+			}
+			decision := authorizeRequest("admin", permissions, request)
+			if decision.Allowed != tt.allowed || decision.Reason != tt.reason {
+				t.Fatalf("unexpected decision: %s", decision)
+			}
+		})
+	}
+
+	// an allow condition failing to evaluate does not grant access
+	permissions = []Permission{
+		{Method: Methods{"GET"}, URI: "/items/{id}", Condition: `claims.level > 5`},
+		{Method: Methods{"GET"}, URI: "/items/public"},
+	}
+	if err := compilePermissions(permissions); err != nil {
+		t.Fatal(err)
+	}
+	request := RequestDetails{Method: "GET", URI: "/items/1", Claims: map[string]any{"level": "high"}}
+	if decision := authorizeRequest("admin", permissions, request); decision.Reason != ReasonConditionError {
+		t.Fatalf("unexpected decision: %s", decision)
+	}
+	request.URI = "/items/public"
+	if decision := authorizeRequest("admin", permissions, request); !decision.Allowed {
+		t.Fatalf("unexpected decision: %s", decision)
+	}
+
+	invalid := Permission{Method: Methods{"GET"}, URI: "/", Condition: "claims.user =="}
+	if err := invalid.Compile(); err == nil {
+		t.Fatal("expected condition compile error")
+	}
+}
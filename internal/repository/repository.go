@@ -3,9 +3,13 @@ package repository
 import (
 	"fmt"
 	"log/slog"
-	"strings"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/reugn/auth-server/internal/policy"
+	"github.com/reugn/auth-server/internal/util/hash"
 )
 
 // UserRole represents a user role.
@@ -13,14 +17,26 @@ type UserRole string
 
 // UserDetails represents user details.
 type UserDetails struct {
-	UserName string
-	UserRole UserRole
+	UserName  string
+	UserRoles []UserRole
+	// Claims contains the additional claims to be included in the token,
+	// e.g. tenant, email, department.
+	Claims map[string]any
 }
 
 // RequestDetails represents request details.
 type RequestDetails struct {
-	Method string `yaml:"method"`
-	URI    string `yaml:"uri"`
+	Method string
+	URI    string
+	// Headers and Query contain the request headers and query parameters.
+	Headers http.Header
+	Query   url.Values
+	// ClientIP is the resolved IP address of the client.
+	ClientIP string
+	// Claims contains the claims of the token the request is authorized with.
+	Claims map[string]any
+	// Time is the time of the request, the current time if not set.
+	Time time.Time
 }
 
 // String implements the fmt.Stringer interface.
@@ -28,6 +44,66 @@ func (r RequestDetails) String() string {
 	return fmt.Sprintf("%s %s", r.Method, r.URI)
 }
 
+// input returns the input to evaluate the permission conditions against.
+func (r RequestDetails) input() *policy.Input {
+	return &policy.Input{
+		Method:  r.Method,
+		URI:     r.URI,
+		Headers: r.Headers,
+		IP:      r.ClientIP,
+		Query:   r.Query,
+		Claims:  r.Claims,
+		Time:    r.Time,
+	}
+}
+
+// Reason represents the reason of the authorization decision.
+type Reason string
+
+const (
+	// ReasonRuleMatched is the reason of the decisions made by a matching rule.
+	ReasonRuleMatched Reason = "rule_matched"
+	// ReasonExplicitDeny is the reason of the decisions made by a matching deny rule.
+	ReasonExplicitDeny Reason = "explicit_deny"
+	// ReasonNoMatchingRule is the reason of the denials when no rule matched the request.
+	ReasonNoMatchingRule Reason = "no_matching_rule"
+	// ReasonRepositoryError is the reason of the denials when the permissions could not be fetched.
+	ReasonRepositoryError Reason = "repository_error"
+	// ReasonConditionError is the reason of the denials when the condition of a matching
+	// permission failed to evaluate, and no other permission granted access.
+	ReasonConditionError Reason = "condition_error"
+)
+
+// Failed reports whether the decision was made due to an error rather than
+// by the permissions of the role.
+func (r Reason) Failed() bool {
+	return r == ReasonRepositoryError || r == ReasonConditionError
+}
+
+// Decision represents the result of the request authorization.
+type Decision struct {
+	// Allowed reports whether access to the request is granted.
+	Allowed bool
+	// Reason is the reason of the decision.
+	Reason Reason
+	// Role is the evaluated user role.
+	Role UserRole
+	// Rule is the permission that decided, nil if no rule matched the request.
+	Rule *Permission
+}
+
+// String implements the fmt.Stringer interface.
+func (d Decision) String() string {
+	effect := "deny"
+	if d.Allowed {
+		effect = "allow"
+	}
+	if d.Rule == nil {
+		return fmt.Sprintf("%s (%s): role %s", effect, d.Reason, d.Role)
+	}
+	return fmt.Sprintf("%s (%s): role %s, rule %s", effect, d.Reason, d.Role, d.Rule)
+}
+
 // A Repository acts as a gateway to the authentication and authorization
 // operations, facilitating secure access to resources.
 type Repository interface {
@@ -35,38 +111,89 @@ type Repository interface {
 	// AuthenticateBasic validates the basic username and password before issuing a JWT.
 	AuthenticateBasic(username string, password string) *UserDetails
 
+	// LookupUser returns the details of the user without verifying the credentials,
+	// e.g. to reissue a token. It returns nil if the user does not exist.
+	LookupUser(username string) (*UserDetails, error)
+
 	// AuthorizeRequest checks if the role has permissions to access the endpoint.
-	AuthorizeRequest(userRole UserRole, request RequestDetails) bool
+	// It returns the decision along with the rule that matched the request.
+	AuthorizeRequest(userRole UserRole, request RequestDetails) Decision
 }
 
-func isAuthorizedRequest(scopes []map[string]string, request RequestDetails) bool {
-	for _, scope := range scopes {
-		if (scope["method"] == "*" || scope["method"] == request.Method) &&
-			(scope["uri"] == "*" || strings.HasPrefix(request.URI, scope["uri"])) {
-			slog.Debug("Request authorized", "request", request)
-			return true
-		}
+// toUserDetails converts the user details fetched from a storage backend.
+func toUserDetails(username string, data map[string]any) *UserDetails {
+	return &UserDetails{
+		UserName:  username,
+		UserRoles: toUserRoles(data["role"], data["roles"]),
+		Claims:    toStringMap(data["claims"]),
 	}
-	slog.Debug("Authorization failed for the request", "request", request)
-	return false
 }
 
-func HashAndSalt(pwd string) ([]byte, error) {
-	bytePwd := []byte(pwd)
+// toUserRoles converts the single role and the list of roles fetched from
+// a storage backend to a list of unique user roles.
+func toUserRoles(role any, roles any) []UserRole {
+	userRoles := make([]UserRole, 0)
+	if role, ok := role.(string); ok && role != "" {
+		userRoles = append(userRoles, UserRole(role))
+	}
+	for _, role := range toStringSlice(roles) {
+		if !slices.Contains(userRoles, UserRole(role)) {
+			userRoles = append(userRoles, UserRole(role))
+		}
+	}
+	return userRoles
+}
 
-	// use bcrypt.GenerateFromPassword to hash and salt the password
-	hash, err := bcrypt.GenerateFromPassword(bytePwd, bcrypt.MinCost)
-	if err != nil {
-		return nil, err
+// toStringMap converts a map fetched from a storage backend.
+func toStringMap(value any) map[string]any {
+	switch claims := value.(type) {
+	case map[string]any:
+		return claims
+	case map[any]any:
+		result := make(map[string]any, len(claims))
+		for key, value := range claims {
+			if key, ok := key.(string); ok {
+				result[key] = value
+			}
+		}
+		return result
+	default:
+		return nil
 	}
+}
 
-	return hash, nil
+// PasswordUpdater is implemented by the repositories supporting writes, to upgrade
+// the outdated password hashes on successful authentication.
+type PasswordUpdater interface {
+	// UpdatePassword replaces the password hash of the user.
+	UpdatePassword(username string, hashed string) error
 }
 
-func pwdMatch(hashed string, plain string) bool {
-	hashedBytes := []byte(hashed)
-	plainBytes := []byte(plain)
+// pwdMatch reports whether the plain password matches the hashed one
+// in any of the supported formats.
+func pwdMatch(hasher hash.PasswordHasher, hashed string, plain string) bool {
+	ok, err := hasher.Verify(hashed, plain)
+	if err != nil {
+		slog.Debug("Failed to verify password", "err", err)
+	}
+	return ok
+}
 
-	err := bcrypt.CompareHashAndPassword(hashedBytes, plainBytes)
-	return err == nil
+// rehashPassword upgrades the verified password hash of the user if it was
+// produced by a different algorithm or with different parameters.
+func rehashPassword(hasher hash.PasswordHasher, updater PasswordUpdater, username string,
+	hashed string, plain string) {
+	if !hasher.NeedsRehash(hashed) {
+		return
+	}
+	rehashed, err := hasher.Hash(plain)
+	if err != nil {
+		slog.Error("Failed to rehash password", "user", username, "err", err)
+		return
+	}
+	if err = updater.UpdatePassword(username, rehashed); err != nil {
+		slog.Error("Failed to update password hash", "user", username, "err", err)
+		return
+	}
+	slog.Debug("Password hash upgraded", "user", username)
 }
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/reugn/auth-server/internal/util/hash"
+)
+
+// newTestHasher returns a hasher using the algorithm with the low cost parameters.
+func newTestHasher(t *testing.T, algorithm string) hash.PasswordHasher {
+	t.Helper()
+	params := hash.DefaultPasswordParams()
+	params.Algorithm = algorithm
+	params.BcryptCost = 4
+	params.Argon2Memory = 1024
+	params.ScryptLogN = 10
+	hasher, err := hash.NewHasher(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+type testPasswordUpdater struct {
+	passwords map[string]string
+	err       error
+}
+
+func (u *testPasswordUpdater) UpdatePassword(username string, hashed string) error {
+	if u.err != nil {
+		return u.err
+	}
+	u.passwords[username] = hashed
+	return nil
+}
+
+func TestRehashPassword(t *testing.T) {
+	bcryptHasher := newTestHasher(t, hash.Bcrypt)
+	argon2Hasher := newTestHasher(t, hash.Argon2id)
+	outdated, err := bcryptHasher.Hash("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updater := &testPasswordUpdater{passwords: map[string]string{}}
+	if !pwdMatch(argon2Hasher, outdated, "1234") {
+		t.Fatal("failed to verify the outdated hash")
+	}
+	rehashPassword(argon2Hasher, updater, "admin", outdated, "1234")
+	upgraded, ok := updater.passwords["admin"]
+	if !ok {
+		t.Fatal("password hash was not upgraded")
+	}
+	if argon2Hasher.NeedsRehash(upgraded) || !pwdMatch(argon2Hasher, upgraded, "1234") {
+		t.Fatalf("unexpected upgraded hash: %s", upgraded)
+	}
+
+	delete(updater.passwords, "admin")
+	rehashPassword(argon2Hasher, updater, "admin", upgraded, "1234")
+	if _, ok := updater.passwords["admin"]; ok {
+		t.Fatal("up-to-date password hash was upgraded")
+	}
+
+	// the update failure is not propagated to the authentication
+	updater.err = errors.New("read-only")
+	rehashPassword(argon2Hasher, updater, "admin", outdated, "1234")
+}
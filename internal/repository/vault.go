@@ -6,14 +6,16 @@ import (
 
 	"github.com/hashicorp/vault/api"
 	"github.com/reugn/auth-server/internal/util/env"
+	"github.com/reugn/auth-server/internal/util/hash"
 )
 
 // Environment variables to configure VaultRepository.
 const (
-	envVaultAddr     = "AUTH_SERVER_VAULT_ADDR"
-	envVaultToken    = "AUTH_SERVER_VAULT_TOKEN"
-	envVaultBasicKey = "AUTH_SERVER_VAULT_BASIC_KEY"
-	envVaultAuthKey  = "AUTH_SERVER_VAULT_AUTHORIZATION_KEY"
+	envVaultAddr      = "AUTH_SERVER_VAULT_ADDR"
+	envVaultToken     = "AUTH_SERVER_VAULT_TOKEN"
+	envVaultBasicKey  = "AUTH_SERVER_VAULT_BASIC_KEY"
+	envVaultAuthKey   = "AUTH_SERVER_VAULT_AUTHORIZATION_KEY"
+	envVaultClientKey = "AUTH_SERVER_VAULT_CLIENT_KEY"
 )
 
 // vaultConfig contains VaultRepository configuration properties.
@@ -22,6 +24,7 @@ type vaultConfig struct {
 	vaultToken             string
 	basicAuthKeyPrefix     string
 	authorizationKeyPrefix string
+	clientKeyPrefix        string
 }
 
 // VaultRepository implements the Repository interface using HashiCorp Vault
@@ -29,9 +32,16 @@ type vaultConfig struct {
 type VaultRepository struct {
 	client *api.Client
 	config vaultConfig
+	hasher hash.PasswordHasher
+	// permissions caches the compiled role permissions.
+	permissions *permissionCache
 }
 
-var _ Repository = (*VaultRepository)(nil)
+var (
+	_ Repository      = (*VaultRepository)(nil)
+	_ ClientRegistry  = (*VaultRepository)(nil)
+	_ PasswordUpdater = (*VaultRepository)(nil)
+)
 
 func getVaultConfig() vaultConfig {
 	// set defaults
@@ -39,6 +49,7 @@ func getVaultConfig() vaultConfig {
 		vaultAddr:              "localhost:8200",
 		basicAuthKeyPrefix:     "secret/basic",
 		authorizationKeyPrefix: "secret/authorization",
+		clientKeyPrefix:        "secret/clients",
 	}
 
 	// read configuration from environment variables
@@ -46,12 +57,14 @@ func getVaultConfig() vaultConfig {
 	env.ReadString(&config.vaultToken, envVaultToken)
 	env.ReadString(&config.basicAuthKeyPrefix, envVaultBasicKey)
 	env.ReadString(&config.authorizationKeyPrefix, envVaultAuthKey)
+	env.ReadString(&config.clientKeyPrefix, envVaultClientKey)
 
 	return config
 }
 
 // NewVault returns a new VaultRepository using environment variables for configuration.
-func NewVault() (*VaultRepository, error) {
+// The hasher verifies the password hashes and upgrades the outdated ones.
+func NewVault(hasher hash.PasswordHasher) (*VaultRepository, error) {
 	config := getVaultConfig() // read configuration
 	apiConfig := &api.Config{
 		Address: config.vaultAddr,
@@ -65,45 +78,120 @@ func NewVault() (*VaultRepository, error) {
 	return &VaultRepository{
 		client: client,
 		config: config,
+		hasher: hasher,
+
+		permissions: newPermissionCache(),
 	}, nil
 }
 
 // AuthenticateBasic validates the basic username and password before issuing a JWT.
-// It uses the bcrypt password-hashing function to validate the password.
+// The outdated password hash is upgraded on successful authentication.
 func (vr *VaultRepository) AuthenticateBasic(username string, password string) *UserDetails {
+	data, err := vr.readUser(username)
+	if err != nil {
+		slog.Error("Failed to read user", "user", username, "err", err)
+		return nil
+	}
+	if data == nil {
+		slog.Debug("User not found", "user", username)
+		return nil
+	}
+
+	hashed, ok := data["password"].(string)
+	if !ok || !pwdMatch(vr.hasher, hashed, password) {
+		slog.Debug("Failed to authenticate", "user", username)
+		return nil
+	}
+	rehashPassword(vr.hasher, vr, username, hashed, password)
+
+	return toUserDetails(username, data)
+}
+
+// LookupUser returns the details of the user without verifying the password.
+func (vr *VaultRepository) LookupUser(username string) (*UserDetails, error) {
+	data, err := vr.readUser(username)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return toUserDetails(username, data), nil
+}
+
+// readUser returns the secret data of the user, nil if the user does not exist.
+func (vr *VaultRepository) readUser(username string) (map[string]interface{}, error) {
 	path := fmt.Sprintf("%s/%s", vr.config.basicAuthKeyPrefix, username)
 	secret, err := vr.client.Logical().Read(path)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+// AuthenticateClient validates the client id and secret before issuing a JWT.
+func (vr *VaultRepository) AuthenticateClient(clientID string, clientSecret string) *ClientDetails {
+	path := fmt.Sprintf("%s/%s", vr.config.clientKeyPrefix, clientID)
+	secret, err := vr.client.Logical().Read(path)
 	if err != nil {
 		slog.Error("Failed to read path", "path", path, "err", err)
 		return nil
 	}
+	if secret == nil {
+		slog.Debug("Client not found", "client", clientID)
+		return nil
+	}
 
-	hashed, ok := secret.Data["password"].(string)
-	if !ok || !pwdMatch(hashed, password) {
-		slog.Debug("Failed to authenticate", "user", username)
+	hashed, ok := secret.Data["secret"].(string)
+	if !ok || !pwdMatch(vr.hasher, hashed, clientSecret) {
+		slog.Debug("Failed to authenticate client", "client", clientID)
 		return nil
 	}
 
-	return &UserDetails{
-		UserName: username,
-		UserRole: secret.Data["role"].(UserRole),
+	role, _ := secret.Data["role"].(string)
+	return &ClientDetails{
+		ClientID:   clientID,
+		ClientRole: UserRole(role),
+		Scopes:     toStringSlice(secret.Data["scopes"]),
+	}
+}
+
+// UpdatePassword replaces the password hash of the user, preserving the other
+// properties of the secret.
+func (vr *VaultRepository) UpdatePassword(username string, hashed string) error {
+	path := fmt.Sprintf("%s/%s", vr.config.basicAuthKeyPrefix, username)
+	secret, err := vr.client.Logical().Read(path)
+	if err != nil {
+		return err
 	}
+	if secret == nil {
+		return fmt.Errorf("user not found: %s", username)
+	}
+	secret.Data["password"] = hashed
+	_, err = vr.client.Logical().Write(path, secret.Data)
+	return err
 }
 
 // AuthorizeRequest checks if the role has permissions to access the endpoint.
-func (vr *VaultRepository) AuthorizeRequest(userRole UserRole, request RequestDetails) bool {
+func (vr *VaultRepository) AuthorizeRequest(userRole UserRole, request RequestDetails) Decision {
 	path := fmt.Sprintf("%s/%s", vr.config.authorizationKeyPrefix, userRole)
 	secret, err := vr.client.Logical().Read(path)
 	if err != nil {
 		slog.Error("Failed to read path", "path", path, "err", err)
-		return false
+		return Decision{Reason: ReasonRepositoryError, Role: userRole}
 	}
 
-	scopes, ok := secret.Data["scopes"].([]map[string]string)
+	if secret == nil {
+		slog.Debug("Role not found", "role", userRole)
+		return Decision{Reason: ReasonNoMatchingRule, Role: userRole}
+	}
+	scopes, ok := secret.Data["scopes"]
 	if !ok {
 		slog.Error("Error reading scopes", "role", userRole)
-		return false
+		return Decision{Reason: ReasonRepositoryError, Role: userRole}
 	}
 
-	return isAuthorizedRequest(scopes, request)
+	permissions, err := vr.permissions.get(userRole, scopes)
+	if err != nil {
+		slog.Error("Invalid permissions", "role", userRole, "err", err)
+		return Decision{Reason: ReasonRepositoryError, Role: userRole}
+	}
+	return authorizeRequest(userRole, permissions, request)
 }
@@ -10,6 +10,7 @@ func Test_getVaultConfig(t *testing.T) {
 	os.Setenv(envVaultToken, "token1")
 	os.Setenv(envVaultBasicKey, "secret/basic1")
 	os.Setenv(envVaultAuthKey, "secret/authorization1")
+	os.Setenv(envVaultClientKey, "secret/clients1")
 
 	config := getVaultConfig()
 	if config.vaultAddr != "127.0.0.1:8200" {
@@ -24,4 +25,7 @@ func Test_getVaultConfig(t *testing.T) {
 	if config.authorizationKeyPrefix != "secret/authorization1" {
 		t.Fail()
 	}
+	if config.clientKeyPrefix != "secret/clients1" {
+		t.Fail()
+	}
 }
@@ -38,3 +38,15 @@ func ReadTime(value *time.Duration, key string, timeUnit time.Duration) {
 		}
 	}
 }
+
+// ReadBool retrieves the boolean value of the environment variable named
+// by the key.
+func ReadBool(value *bool, key string) {
+	envValue, ok := os.LookupEnv(key)
+	if ok {
+		boolValue, err := strconv.ParseBool(envValue)
+		if err == nil {
+			*value = boolValue
+		}
+	}
+}
@@ -11,3 +11,113 @@ func TestSha256(t *testing.T) {
 		t.Fatal("Sha256")
 	}
 }
+
+// newTestHasher returns a hasher using the algorithm with the low cost parameters.
+func newTestHasher(t *testing.T, algorithm string) *hash.Hasher {
+	t.Helper()
+	params := hash.DefaultPasswordParams()
+	params.Algorithm = algorithm
+	params.BcryptCost = 4
+	params.Argon2Memory = 1024
+	params.ScryptLogN = 10
+	hasher, err := hash.NewHasher(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func TestHasher(t *testing.T) {
+	for _, algorithm := range []string{hash.Bcrypt, hash.Argon2id, hash.Scrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			hasher := newTestHasher(t, algorithm)
+			hashed, err := hasher.Hash("1234")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !hash.IsPasswordHash(hashed) {
+				t.Fatalf("not recognized as a password hash: %s", hashed)
+			}
+			if ok, err := hasher.Verify(hashed, "1234"); !ok || err != nil {
+				t.Fatalf("failed to verify the password: %v", err)
+			}
+			if ok, err := hasher.Verify(hashed, "12345"); ok || err != nil {
+				t.Fatalf("verified a wrong password: %v", err)
+			}
+			if hasher.NeedsRehash(hashed) {
+				t.Fatal("unexpected rehash of the current hash")
+			}
+		})
+	}
+}
+
+func TestHasher_NeedsRehash(t *testing.T) {
+	bcryptHash, _ := newTestHasher(t, hash.Bcrypt).Hash("1234")
+	argon2Hash, _ := newTestHasher(t, hash.Argon2id).Hash("1234")
+	scryptHash, _ := newTestHasher(t, hash.Scrypt).Hash("1234")
+
+	params := hash.DefaultPasswordParams()
+	params.Algorithm = hash.Argon2id
+	params.Argon2Memory = 1024
+	params.Argon2Time = 2
+	hasher, err := hash.NewHasher(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		encoded string
+		rehash  bool
+	}{
+		{bcryptHash, true},
+		{argon2Hash, true},
+		{scryptHash, true},
+		{"1234", false},
+		{"$argon2id$v=19$m=1024,t=2,p=4$c2FsdA$a2V5", false},
+		{"$argon2id$v=19$m=1024,t=2,p=2$c2FsdA$a2V5", true},
+	}
+	for _, tt := range tests {
+		if hasher.NeedsRehash(tt.encoded) != tt.rehash {
+			t.Fatalf("unexpected rehash result for %s", tt.encoded)
+		}
+	}
+}
+
+func TestPasswordParams_Validate(t *testing.T) {
+	tests := []func(*hash.PasswordParams){
+		func(p *hash.PasswordParams) { p.Algorithm = "md5" },
+		func(p *hash.PasswordParams) { p.BcryptCost = 3 },
+		func(p *hash.PasswordParams) { p.BcryptCost = 32 },
+		func(p *hash.PasswordParams) { p.Algorithm = hash.Argon2id; p.Argon2Time = 0 },
+		func(p *hash.PasswordParams) { p.Algorithm = hash.Argon2id; p.Argon2Memory = 16 },
+		func(p *hash.PasswordParams) { p.Algorithm = hash.Scrypt; p.ScryptLogN = 0 },
+		func(p *hash.PasswordParams) { p.Algorithm = hash.Scrypt; p.ScryptP = 0 },
+	}
+	for i, modify := range tests {
+		params := hash.DefaultPasswordParams()
+		modify(&params)
+		if _, err := hash.NewHasher(params); err == nil {
+			t.Fatalf("%d: expected a validation error", i)
+		}
+	}
+}
+
+func TestVerifyPassword_Invalid(t *testing.T) {
+	tests := []string{
+		"1234",
+		"$2a$10$invalid",
+		"$argon2id$v=19$m=65536,t=3$c2FsdA$a2V5",
+		"$argon2id$v=18$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$!",
+		"$scrypt$ln=15,r=8,p=0$c2FsdA$a2V5",
+		"$scrypt$ln=15,r=8,p=1,x=1$c2FsdA$a2V5",
+		"$scrypt$ln=15,r=8,p=1$c2FsdA",
+	}
+	for _, encoded := range tests {
+		if ok, err := hash.VerifyPassword(encoded, "1234"); ok || err == nil {
+			t.Fatalf("expected an error for %s", encoded)
+		}
+	}
+	if hash.IsPasswordHash("1234") {
+		t.Fatal("plaintext recognized as a password hash")
+	}
+}
@@ -0,0 +1,329 @@
+package hash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Supported password hashing algorithms.
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+	Scrypt   = "scrypt"
+)
+
+const (
+	saltLength = 16
+	keyLength  = 32
+
+	maxScryptLogN = 24
+)
+
+// ErrUnsupportedHash is returned when the password hash format is not recognized.
+var ErrUnsupportedHash = errors.New("unsupported password hash")
+
+// PasswordHasher hashes and verifies passwords.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the password.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash in any of
+	// the supported formats.
+	Verify(encoded string, password string) (bool, error)
+	// NeedsRehash reports whether the encoded hash was produced by a different
+	// algorithm or with different parameters, and should be upgraded.
+	NeedsRehash(encoded string) bool
+}
+
+// PasswordParams contains the password hashing algorithm and its cost parameters.
+type PasswordParams struct {
+	Algorithm string
+	// BcryptCost is the bcrypt cost factor.
+	BcryptCost int
+	// Argon2Memory is the argon2id memory size in KiB.
+	Argon2Memory uint32
+	// Argon2Time is the argon2id number of iterations.
+	Argon2Time uint32
+	// Argon2Threads is the argon2id degree of parallelism.
+	Argon2Threads uint8
+	// ScryptLogN is the base 2 logarithm of the scrypt CPU/memory cost.
+	ScryptLogN int
+	// ScryptR is the scrypt block size.
+	ScryptR int
+	// ScryptP is the scrypt parallelization factor.
+	ScryptP int
+}
+
+// DefaultPasswordParams returns the default password hashing parameters,
+// using the bcrypt algorithm.
+func DefaultPasswordParams() PasswordParams {
+	return PasswordParams{
+		Algorithm:     Bcrypt,
+		BcryptCost:    12,
+		Argon2Memory:  64 * 1024,
+		Argon2Time:    3,
+		Argon2Threads: 4,
+		ScryptLogN:    15,
+		ScryptR:       8,
+		ScryptP:       1,
+	}
+}
+
+// Validate validates the password hashing parameters of the configured algorithm.
+func (p *PasswordParams) Validate() error {
+	switch p.Algorithm {
+	case Bcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("invalid bcrypt cost: %d", p.BcryptCost)
+		}
+	case Argon2id:
+		if p.Argon2Time == 0 || p.Argon2Threads == 0 {
+			return errors.New("argon2id time and threads must be positive")
+		}
+		if p.Argon2Memory < 8*uint32(p.Argon2Threads) {
+			return fmt.Errorf("argon2id memory must be at least %d KiB", 8*uint32(p.Argon2Threads))
+		}
+	case Scrypt:
+		if p.ScryptLogN < 1 || p.ScryptLogN > maxScryptLogN {
+			return fmt.Errorf("invalid scrypt ln: %d", p.ScryptLogN)
+		}
+		if p.ScryptR < 1 || p.ScryptP < 1 || p.ScryptR*p.ScryptP >= 1<<30 {
+			return fmt.Errorf("invalid scrypt r and p: %d, %d", p.ScryptR, p.ScryptP)
+		}
+	default:
+		return fmt.Errorf("unsupported hashing algorithm: %s", p.Algorithm)
+	}
+	return nil
+}
+
+// Hasher implements the PasswordHasher interface using the configured algorithm
+// and parameters.
+type Hasher struct {
+	params PasswordParams
+}
+
+var _ PasswordHasher = (*Hasher)(nil)
+
+// NewHasher returns a new Hasher using the parameters.
+func NewHasher(params PasswordParams) (*Hasher, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return &Hasher{params: params}, nil
+}
+
+// NewDefaultHasher returns a new Hasher using the default parameters.
+func NewDefaultHasher() *Hasher {
+	return &Hasher{params: DefaultPasswordParams()}
+}
+
+// Hash returns the encoded hash of the password.
+func (h *Hasher) Hash(password string) (string, error) {
+	switch h.params.Algorithm {
+	case Bcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.params.BcryptCost)
+		return string(hashed), err
+	case Argon2id:
+		salt, err := newSalt()
+		if err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.params.Argon2Time, h.params.Argon2Memory,
+			h.params.Argon2Threads, keyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.params.Argon2Memory,
+			h.params.Argon2Time, h.params.Argon2Threads, encode(salt), encode(key)), nil
+	case Scrypt:
+		salt, err := newSalt()
+		if err != nil {
+			return "", err
+		}
+		key, err := scrypt.Key([]byte(password), salt, 1<<h.params.ScryptLogN, h.params.ScryptR,
+			h.params.ScryptP, keyLength)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", h.params.ScryptLogN, h.params.ScryptR,
+			h.params.ScryptP, encode(salt), encode(key)), nil
+	default:
+		return "", fmt.Errorf("unsupported hashing algorithm: %s", h.params.Algorithm)
+	}
+}
+
+// Verify reports whether the password matches the encoded hash in any of the
+// supported formats.
+func (h *Hasher) Verify(encoded string, password string) (bool, error) {
+	return VerifyPassword(encoded, password)
+}
+
+// NeedsRehash reports whether the encoded hash was produced by a different
+// algorithm or with different parameters.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	algorithm := passwordAlgorithm(encoded)
+	if algorithm == "" {
+		return false
+	}
+	if algorithm != h.params.Algorithm {
+		return true
+	}
+	switch algorithm {
+	case Bcrypt:
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err == nil && cost != h.params.BcryptCost
+	case Argon2id:
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 6 {
+			return false
+		}
+		params, err := parseParams(parts[3], "m", "t", "p")
+		return err == nil && (uint32(params["m"]) != h.params.Argon2Memory ||
+			uint32(params["t"]) != h.params.Argon2Time || params["p"] != int(h.params.Argon2Threads))
+	default:
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 5 {
+			return false
+		}
+		params, err := parseParams(parts[2], "ln", "r", "p")
+		return err == nil && (params["ln"] != h.params.ScryptLogN ||
+			params["r"] != h.params.ScryptR || params["p"] != h.params.ScryptP)
+	}
+}
+
+// IsPasswordHash reports whether the string is a password hash in one of the
+// supported formats, identified by the prefix:
+//   - bcrypt: $2a$, $2b$ or $2y$
+//   - argon2id: $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+//   - scrypt: $scrypt$ln=15,r=8,p=1$<salt>$<hash>
+func IsPasswordHash(encoded string) bool {
+	return passwordAlgorithm(encoded) != ""
+}
+
+// passwordAlgorithm returns the hashing algorithm of the encoded password hash,
+// or an empty string if the format is not recognized.
+func passwordAlgorithm(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"),
+		strings.HasPrefix(encoded, "$2y$"):
+		return Bcrypt
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return Argon2id
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		return Scrypt
+	default:
+		return ""
+	}
+}
+
+// VerifyPassword reports whether the password matches the encoded password hash.
+// It returns an error if the hash is malformed or its format is not supported.
+func VerifyPassword(encoded string, password string) (bool, error) {
+	switch passwordAlgorithm(encoded) {
+	case Bcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case Argon2id:
+		return verifyArgon2id(encoded, password)
+	case Scrypt:
+		return verifyScrypt(encoded, password)
+	default:
+		return false, ErrUnsupportedHash
+	}
+}
+
+// verifyArgon2id verifies the password against the argon2id hash.
+func verifyArgon2id(encoded string, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false, errors.New("malformed argon2id hash")
+	}
+	params, err := parseParams(parts[3], "m", "t", "p")
+	if err != nil || params["p"] > 255 {
+		return false, errors.New("malformed argon2id hash parameters")
+	}
+	salt, key, err := decodeSaltAndKey(parts[4], parts[5])
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey([]byte(password), salt, uint32(params["t"]), uint32(params["m"]),
+		uint8(params["p"]), uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, computed) == 1, nil
+}
+
+// verifyScrypt verifies the password against the scrypt hash.
+func verifyScrypt(encoded string, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return false, errors.New("malformed scrypt hash")
+	}
+	params, err := parseParams(parts[2], "ln", "r", "p")
+	if err != nil || params["ln"] > maxScryptLogN {
+		return false, errors.New("malformed scrypt hash parameters")
+	}
+	salt, key, err := decodeSaltAndKey(parts[3], parts[4])
+	if err != nil {
+		return false, err
+	}
+	computed, err := scrypt.Key([]byte(password), salt, 1<<params["ln"], params["r"], params["p"], len(key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, computed) == 1, nil
+}
+
+// parseParams parses the comma-separated list of positive integer parameters,
+// e.g. "m=65536,t=3,p=4", requiring exactly the specified names.
+func parseParams(value string, names ...string) (map[string]int, error) {
+	params := make(map[string]int, len(names))
+	for _, param := range strings.Split(value, ",") {
+		name, number, _ := strings.Cut(param, "=")
+		n, err := strconv.ParseUint(number, 10, 31)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid parameter: %s", param)
+		}
+		params[name] = int(n)
+	}
+	for _, name := range names {
+		if _, ok := params[name]; !ok {
+			return nil, fmt.Errorf("missing parameter: %s", name)
+		}
+	}
+	if len(params) != len(names) {
+		return nil, fmt.Errorf("unexpected parameters: %s", value)
+	}
+	return params, nil
+}
+
+// decodeSaltAndKey decodes the base64 encoded salt and key of the hash.
+func decodeSaltAndKey(encodedSalt, encodedKey string) ([]byte, []byte, error) {
+	salt, err := base64.RawStdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed hash salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) == 0 {
+		return nil, nil, errors.New("malformed hash key")
+	}
+	return salt, key, nil
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func encode(data []byte) string {
+	return base64.RawStdEncoding.EncodeToString(data)
+}